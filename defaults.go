@@ -0,0 +1,51 @@
+package copre
+
+import (
+	"reflect"
+	"strings"
+)
+
+const defaultTag = "default"
+
+// Defaults implements a Loader, that populates fields with the value of their
+// "default"-tag. Values are converted the same way Env converts environment
+// variables, so all types supported by Env can be used.
+//
+// Defaults accepts the EnvOption values related to the conversion of strings,
+// e.g. WithDecoder, WithTimeLayout or WithSliceDelimiter, while others have no effect.
+//
+// As loaders are merged in the specified order, Defaults usually comes first:
+//  cfg := struct{
+//    Port    int           `default:"8080" env:"PORT"`
+//    Timeout time.Duration `default:"5s"`
+//    Hosts   []string      `default:"a.example.com,b.example.com"`
+//  }{}
+//  err := Load(&cfg, Defaults(), Env(WithPrefix("MYAPP")))
+func Defaults(opts ...EnvOption) Loader {
+	o := defaultEnvOptions()
+	for _, opt := range opts {
+		opt.apply(&o)
+	}
+	c := o.converter()
+	return trackedLoaderFunc(func(dst interface{}, t *tracker) error {
+		return structWalk(dst, func(path []string, field reflect.StructField) (interface{}, error) {
+			val, ok := field.Tag.Lookup(defaultTag)
+			if !ok {
+				return nil, nil
+			}
+			src := Source{Kind: SourceDefault}
+			v, err := c.convert(field.Type, val)
+			if err != nil {
+				return nil, &FieldError{
+					Path:       strings.Join(path, "."),
+					Source:     src,
+					RawValue:   val,
+					TargetType: field.Type,
+					Err:        err,
+				}
+			}
+			t.track(path, src)
+			return v, nil
+		}, walkOptions{collectErrors: t.collecting(), isLeaf: c.isLeaf})
+	})
+}
@@ -0,0 +1,59 @@
+package copre
+
+import (
+	"net"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type TestConfigDefaults struct {
+	Port    int               `default:"8080" env:"PORT"`
+	Timeout time.Duration     `default:"5s"`
+	IP      net.IP            `default:"127.0.0.1"`
+	Hosts   []string          `default:"a,b"`
+	Labels  map[string]string `default:"a=b"`
+	Name    string
+}
+
+func TestDefaults(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	os.Setenv("DEFAULTS_PORT", "9090")
+	result := TestConfigDefaults{Name: "preset"}
+	report, err := LoadWithReport(&result, Defaults(), Env(WithPrefix("DEFAULTS")))
+	require.NoError(err)
+	assert.Equal(TestConfigDefaults{
+		Port:    9090,
+		Timeout: 5 * time.Second,
+		IP:      net.IPv4(127, 0, 0, 1),
+		Hosts:   []string{"a", "b"},
+		Labels:  map[string]string{"a": "b"},
+		Name:    "preset",
+	}, result)
+	assert.Equal(Source{Kind: SourceEnv, Name: "DEFAULTS_PORT"}, report["Port"])
+	assert.Equal(Source{Kind: SourceDefault}, report["Timeout"])
+	assert.Equal(Source{Kind: SourcePreset}, report["Name"])
+}
+
+func TestDefaultsInvalid(t *testing.T) {
+	result := struct {
+		A int `default:"a"`
+	}{}
+	err := Defaults().Process(&result)
+	assert.Error(t, err)
+}
+
+func TestDefaultsWithDecoder(t *testing.T) {
+	result := struct {
+		Price testMoney `default:"1.50"`
+	}{}
+	err := Defaults(WithDecoder(reflect.TypeOf(testMoney{}), decodeTestMoney)).Process(&result)
+	require.NoError(t, err)
+	assert.Equal(t, testMoney{Cents: 150}, result.Price)
+}
@@ -0,0 +1,231 @@
+package copre
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"strings"
+)
+
+// DotEnv implements a Loader, that populates fields from the variables
+// defined in .env files. The variables are resolved the same way as by Env,
+// so all EnvOption values, e.g. WithPrefix or ComputeEnvKey, are supported.
+// The environment of the process is not modified.
+//
+// The files are read in order and variables defined by later files take
+// precedence. Files, that do not exist, are skipped.
+//
+// The files consist of lines of the form KEY=value, optionally prefixed with
+// "export". Lines starting with "#" are comments. Values can be:
+//  - unquoted, leading and trailing whitespace and comments starting with
+//    " #" are removed
+//  - single-quoted, the value is used as is and can span multiple lines
+//  - double-quoted, the value can span multiple lines and the escape
+//    sequences \n, \r, \t, \", \\ and \$ are supported
+// Unquoted and double-quoted values can reference variables using ${VAR} or
+// $VAR, which are resolved using previously defined variables or otherwise
+// the environment of the process (see WithLookup).
+//
+// For example:
+//  err := Load(&cfg, DotEnv([]string{".env", ".env.local"}, WithPrefix("MYAPP")))
+func DotEnv(paths []string, opts ...EnvOption) Loader {
+	return trackedLoaderFunc(func(dst interface{}, t *tracker) error {
+		o := defaultEnvOptions()
+		for _, opt := range opts {
+			opt.apply(&o)
+		}
+		vars := map[string]string{}
+		for _, path := range paths {
+			data, err := ioutil.ReadFile(path)
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			if err != nil {
+				return &FileError{Path: path, Err: err}
+			}
+			if err := parseDotEnv(string(data), vars, o.lookup); err != nil {
+				return &FileError{Path: path, Err: err}
+			}
+		}
+		env := Env(append(opts[:len(opts):len(opts)], FromMap(vars))...)
+		return env.(trackedLoader).processTracked(dst, t)
+	})
+}
+
+// parseDotEnv parses the contents of a .env file and adds the variables to
+// vars. References to undefined variables are resolved using lookup.
+func parseDotEnv(data string, vars map[string]string, lookup func(string) (string, bool)) error {
+	p := &dotEnvParser{data: data, vars: vars, lookup: lookup}
+	for {
+		p.skip(" \t\r\n")
+		if p.eof() {
+			return nil
+		}
+		if p.peek() == '#' {
+			p.skipLine()
+			continue
+		}
+		if err := p.parseAssignment(); err != nil {
+			return err
+		}
+	}
+}
+
+type dotEnvParser struct {
+	data   string
+	pos    int
+	vars   map[string]string
+	lookup func(string) (string, bool)
+}
+
+func (p *dotEnvParser) eof() bool {
+	return p.pos >= len(p.data)
+}
+
+func (p *dotEnvParser) peek() byte {
+	return p.data[p.pos]
+}
+
+func (p *dotEnvParser) skip(chars string) {
+	for !p.eof() && strings.IndexByte(chars, p.peek()) >= 0 {
+		p.pos++
+	}
+}
+
+// skipLine advances to the beginning of the next line and returns the skipped
+// contents.
+func (p *dotEnvParser) skipLine() string {
+	start := p.pos
+	for !p.eof() && p.peek() != '\n' {
+		p.pos++
+	}
+	return p.data[start:p.pos]
+}
+
+func (p *dotEnvParser) errorf(format string, args ...interface{}) error {
+	line := strings.Count(p.data[:p.pos], "\n") + 1
+	return fmt.Errorf("line %d: %s", line, fmt.Sprintf(format, args...))
+}
+
+func (p *dotEnvParser) parseAssignment() error {
+	if strings.HasPrefix(p.data[p.pos:], "export ") || strings.HasPrefix(p.data[p.pos:], "export\t") {
+		p.pos += len("export")
+		p.skip(" \t")
+	}
+	start := p.pos
+	for !p.eof() && isDotEnvKeyChar(p.peek()) {
+		p.pos++
+	}
+	key := p.data[start:p.pos]
+	if key == "" {
+		return p.errorf("invalid key")
+	}
+	p.skip(" \t")
+	if p.eof() || p.peek() != '=' {
+		return p.errorf("expected '=' after key '%s'", key)
+	}
+	p.pos++
+	p.skip(" \t")
+
+	var value string
+	if !p.eof() && (p.peek() == '\'' || p.peek() == '"') {
+		quote := p.peek()
+		p.pos++
+		start := p.pos
+		for !p.eof() && p.peek() != quote {
+			// Escaped quotes do not terminate double-quoted values
+			if quote == '"' && p.peek() == '\\' {
+				p.pos++
+			}
+			p.pos++
+		}
+		if p.eof() {
+			p.pos = start - 1
+			return p.errorf("unterminated quoted value of key '%s'", key)
+		}
+		value = p.data[start:p.pos]
+		p.pos++
+		if quote == '"' {
+			value = p.expand(value, true)
+		}
+		// Only a comment may follow a quoted value
+		if rest := strings.TrimSpace(p.skipLine()); rest != "" && !strings.HasPrefix(rest, "#") {
+			return p.errorf("unexpected characters after quoted value of key '%s'", key)
+		}
+	} else {
+		value = p.skipLine()
+		if i := strings.Index(value, " #"); i >= 0 {
+			value = value[:i]
+		}
+		value = p.expand(strings.TrimSpace(value), false)
+	}
+	p.vars[key] = value
+	return nil
+}
+
+// expand replaces references to variables in s and, if escapes is set,
+// escape sequences.
+func (p *dotEnvParser) expand(s string, escapes bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if escapes && c == '\\' && i+1 < len(s) {
+			i++
+			switch s[i] {
+			case 'n':
+				b.WriteByte('\n')
+			case 'r':
+				b.WriteByte('\r')
+			case 't':
+				b.WriteByte('\t')
+			case '"', '\\', '$':
+				b.WriteByte(s[i])
+			default:
+				b.WriteByte('\\')
+				b.WriteByte(s[i])
+			}
+			continue
+		}
+		if c == '$' {
+			if name, n := dotEnvReference(s[i+1:]); n > 0 {
+				b.WriteString(p.resolve(name))
+				i += n
+				continue
+			}
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// resolve returns the value of a variable defined previously or provided by
+// lookup. Undefined variables are resolved to an empty string.
+func (p *dotEnvParser) resolve(name string) string {
+	if v, ok := p.vars[name]; ok {
+		return v
+	}
+	v, _ := p.lookup(name)
+	return v
+}
+
+// dotEnvReference returns the name of the variable referenced at the start
+// of s, either as ${VAR} or VAR, and the number of bytes consumed. If s does
+// not start with a reference, n is zero.
+func dotEnvReference(s string) (name string, n int) {
+	if strings.HasPrefix(s, "{") {
+		end := strings.IndexByte(s, '}')
+		if end < 0 {
+			return "", 0
+		}
+		return s[1:end], end + 1
+	}
+	for n < len(s) && isDotEnvKeyChar(s[n]) && s[n] != '.' {
+		n++
+	}
+	return s[:n], n
+}
+
+func isDotEnvKeyChar(c byte) bool {
+	return c == '_' || c == '.' || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9')
+}
@@ -0,0 +1,93 @@
+package copre
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseDotEnv(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	data := `# Comment
+FOO=bar
+export BAZ = qux # inline comment
+EMPTY=
+SINGLE='single $FOO \n'
+DOUBLE="double ${FOO}\n\"quoted\" \$FOO"
+MULTI="first
+second"
+URL=http://example.com/#anchor
+REF=$FOO-${HOME_DIR}/$UNDEFINED.
+`
+	vars := map[string]string{}
+	lookup := func(key string) (string, bool) {
+		if key == "HOME_DIR" {
+			return "/home/me", true
+		}
+		return "", false
+	}
+	err := parseDotEnv(data, vars, lookup)
+	require.NoError(err)
+	assert.Equal(map[string]string{
+		"FOO":    "bar",
+		"BAZ":    "qux",
+		"EMPTY":  "",
+		"SINGLE": `single $FOO \n`,
+		"DOUBLE": "double bar\n\"quoted\" $FOO",
+		"MULTI":  "first\nsecond",
+		"URL":    "http://example.com/#anchor",
+		"REF":    "bar-/home/me/.",
+	}, vars)
+
+	invalid := []string{
+		"FOO",
+		"=bar",
+		"FOO=\"unterminated",
+		"FOO='quoted' trailing",
+	}
+	for _, data := range invalid {
+		err := parseDotEnv(data, map[string]string{}, lookup)
+		assert.Error(err, data)
+	}
+	err = parseDotEnv("A=a\n\nB", map[string]string{}, lookup)
+	assert.EqualError(err, "line 3: expected '=' after key 'B'")
+}
+
+func TestDotEnv(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	dir := t.TempDir()
+	first := filepath.Join(dir, ".env")
+	err := os.WriteFile(first, []byte("MYAPP_HOST=localhost\nMYAPP_PORT=8080\n"), 0600)
+	require.NoError(err)
+	second := filepath.Join(dir, ".env.local")
+	err = os.WriteFile(second, []byte("MYAPP_PORT=9090\nMYAPP_URL=http://${MYAPP_HOST}:${MYAPP_PORT}\n"), 0600)
+	require.NoError(err)
+
+	result := struct {
+		Host string
+		Port int
+		URL  string `env:"URL"`
+	}{}
+	paths := []string{first, filepath.Join(dir, "missing"), second}
+	report, err := LoadWithReport(&result, DotEnv(paths, WithPrefix("MYAPP"), ComputeEnvKey(UpperSnakeCase)))
+	require.NoError(err)
+	assert.Equal("localhost", result.Host)
+	assert.Equal(9090, result.Port)
+	assert.Equal("http://localhost:9090", result.URL)
+	assert.Equal(Source{Kind: SourceEnv, Name: "MYAPP_PORT"}, report["Port"])
+	_, ok := os.LookupEnv("MYAPP_HOST")
+	assert.False(ok)
+
+	err = os.WriteFile(second, []byte("MYAPP_PORT\n"), 0600)
+	require.NoError(err)
+	err = DotEnv(paths).Process(&result)
+	var fileErr *FileError
+	require.ErrorAs(err, &fileErr)
+	assert.Equal(second, fileErr.Path)
+}
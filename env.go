@@ -1,12 +1,21 @@
 package copre
 
 import (
+	"encoding"
 	"encoding/base64"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io/ioutil"
 	"net"
+	"net/netip"
+	"net/url"
 	"os"
 	"reflect"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -15,9 +24,55 @@ import (
 )
 
 type envOptions struct {
-	tag       string
-	prefix    string
-	keyGetter func([]string) string
+	tag        string
+	prefix     string
+	keyGetter  func([]string) string
+	decoders   map[reflect.Type]DecodeFunc
+	timeLayout string
+	sliceDelim string
+	mapDelim   string
+	kvDelim    string
+	decodeJSON bool
+	fileSuffix bool
+	keepNL     bool
+	lookup     func(key string) (string, bool)
+	environ    func() []string
+	strict     bool
+}
+
+func defaultEnvOptions() envOptions {
+	return envOptions{
+		tag:        "env",
+		prefix:     "",
+		keyGetter:  func(s []string) string { return "" },
+		decoders:   map[reflect.Type]DecodeFunc{},
+		timeLayout: time.RFC3339,
+		sliceDelim: arrayDelimiter,
+		mapDelim:   mapDelimiter,
+		kvDelim:    mapKVDelimiter,
+		lookup:     os.LookupEnv,
+		environ:    os.Environ,
+	}
+}
+
+// variables returns the environment variables in the "key=value" format or
+// nil, if they can not be enumerated, see WithLookup.
+func (o *envOptions) variables() []string {
+	if o.environ == nil {
+		return nil
+	}
+	return o.environ()
+}
+
+// converter returns a converter for strings configured by the options.
+func (o *envOptions) converter() *converter {
+	return &converter{
+		decoders:   o.decoders,
+		timeLayout: o.timeLayout,
+		sliceDelim: o.sliceDelim,
+		mapDelim:   o.mapDelim,
+		kvDelim:    o.kvDelim,
+	}
 }
 
 // EnvOption configures how environment variables are used to populate a given structure.
@@ -64,9 +119,219 @@ func OverrideEnvTag(tag string) EnvOption {
 	})
 }
 
+// WithDecoder registers a function to decode strings to values of type typ.
+// Registered decoders take precedence over the builtin conversion, so types
+// not supported otherwise or types of other packages can be used.
+// The option is supported by all loaders that convert strings, e.g. Env
+// and Defaults.
+//
+// For example:
+//  WithDecoder(reflect.TypeOf(decimal.Decimal{}), func(s string) (interface{}, error) {
+//    return decimal.NewFromString(s)
+//  })
+func WithDecoder(typ reflect.Type, decode DecodeFunc) EnvOption {
+	return envOptionAdapter(func(o *envOptions) {
+		o.decoders[typ] = decode
+	})
+}
+
+// WithTimeLayout sets the layout used to parse time.Time values, see
+// time.Parse for details. By default time.RFC3339 is used.
+// The option is supported by all loaders that convert strings, e.g. Env
+// and Defaults.
+func WithTimeLayout(layout string) EnvOption {
+	return envOptionAdapter(func(o *envOptions) {
+		o.timeLayout = layout
+	})
+}
+
+// WithSliceDelimiter sets the delimiter separating the elements of slices,
+// which is "," by default. The delimiter can also be set for individual
+// fields using the "sep" option of the tag, e.g. `env:"HOSTS,sep=;"`.
+// The option is supported by all loaders that convert strings, e.g. Env
+// and Defaults.
+//
+// Elements containing the delimiter can either escape it with a backslash or
+// be enclosed in double quotes, e.g. `a\,b,"c,d"` results in "a,b" and "c,d".
+// Double quotes only start a quoted section at the beginning of an element,
+// elsewhere they are retained, e.g. `pa"ss` remains as is.
+func WithSliceDelimiter(delim string) EnvOption {
+	return envOptionAdapter(func(o *envOptions) {
+		if delim == "" {
+			delim = arrayDelimiter
+		}
+		o.sliceDelim = delim
+	})
+}
+
+// WithMapDelimiters sets the delimiter separating the entries of maps and the
+// delimiter separating key and value of an entry, which are "," and "=" by
+// default. Entries are only split at the first key-value delimiter, so values
+// can contain it. The delimiters can also be set for individual fields using
+// the "sep" and "kvsep" options of the tag, e.g. `env:"LABELS,sep=;,kvsep=:"`.
+// Escaping and quoting work the same way as for slices, see WithSliceDelimiter.
+// The option is supported by all loaders that convert strings, e.g. Env
+// and Defaults.
+func WithMapDelimiters(entryDelim, kvDelim string) EnvOption {
+	return envOptionAdapter(func(o *envOptions) {
+		if entryDelim == "" {
+			entryDelim = mapDelimiter
+		}
+		if kvDelim == "" {
+			kvDelim = mapKVDelimiter
+		}
+		o.mapDelim = entryDelim
+		o.kvDelim = kvDelim
+	})
+}
+
+// DecodeJSON will decode the values of all slice, map and array fields as
+// JSON rather than splitting them at delimiters, e.g.
+// `[{"path":"/api","backend":"api:8080"}]`. This excludes byte slices and
+// types with registered decoders or implementing one of the supported
+// unmarshal interfaces.
+// JSON can also be used for individual fields, including nested structs,
+// using the "json" option of the tag, e.g. `env:"ROUTES,json"`.
+func DecodeJSON(f ...bool) EnvOption {
+	return envOptionAdapter(func(o *envOptions) {
+		v := true
+		if len(f) > 0 {
+			v = f[0]
+		}
+		o.decodeJSON = v
+	})
+}
+
+// SupportFileSuffix allows to read the value of a field from a file, whose
+// path is provided by the environment variable with the suffix "_FILE", e.g.
+// MYAPP_DB_PASSWORD_FILE=/run/secrets/db. This is commonly used to pass
+// secrets to containers. A single trailing newline is removed from the
+// contents, unless KeepTrailingNewline is specified.
+// The suffix can also be enabled for individual fields using the "file"
+// option of the tag, e.g. `env:"DB_PASSWORD,file"`.
+// Setting both variables, with and without suffix, results in an error.
+func SupportFileSuffix(f ...bool) EnvOption {
+	return envOptionAdapter(func(o *envOptions) {
+		v := true
+		if len(f) > 0 {
+			v = f[0]
+		}
+		o.fileSuffix = v
+	})
+}
+
+// KeepTrailingNewline retains trailing newlines of files read due to the
+// "_FILE" suffix, see SupportFileSuffix.
+func KeepTrailingNewline(f ...bool) EnvOption {
+	return envOptionAdapter(func(o *envOptions) {
+		v := true
+		if len(f) > 0 {
+			v = f[0]
+		}
+		o.keepNL = v
+	})
+}
+
+// WithLookup replaces the process environment with lookup as source of
+// environment variables, e.g. to provide them in tests without os.Setenv.
+// As variables can not be enumerated using lookup, slices and maps populated
+// from multiple variables are not supported, see FromMap instead.
+func WithLookup(lookup func(key string) (string, bool)) EnvOption {
+	return envOptionAdapter(func(o *envOptions) {
+		o.lookup = lookup
+		o.environ = nil
+	})
+}
+
+// FromMap replaces the process environment with the variables of env.
+// For example:
+//  Env(WithPrefix("MYAPP"), FromMap(map[string]string{"MYAPP_PORT": "8080"}))
+func FromMap(env map[string]string) EnvOption {
+	return envOptionAdapter(func(o *envOptions) {
+		o.lookup = func(key string) (string, bool) {
+			v, ok := env[key]
+			return v, ok
+		}
+		o.environ = func() []string {
+			environ := make([]string, 0, len(env))
+			for k, v := range env {
+				environ = append(environ, k+"="+v)
+			}
+			return environ
+		}
+	})
+}
+
+// FromEnviron replaces the process environment with the variables of env,
+// which is expected in the "key=value" format of os.Environ and exec.Cmd.
+// If a key occurs multiple times, the last value is used.
+func FromEnviron(env []string) EnvOption {
+	m := make(map[string]string, len(env))
+	for _, kv := range env {
+		if i := strings.Index(kv, "="); i >= 0 {
+			m[kv[:i]] = kv[i+1:]
+		}
+	}
+	return FromMap(m)
+}
+
+// StrictPrefix makes Env return an *UnknownEnvVarsError, if environment
+// variables carrying the prefix set by WithPrefix do not map to any field,
+// e.g. due to a typo. The error suggests the most similar variable mapping to
+// a field. Without a prefix the option has no effect.
+// As variables can not be enumerated using WithLookup, combining both results
+// in an error.
+func StrictPrefix(f ...bool) EnvOption {
+	return envOptionAdapter(func(o *envOptions) {
+		v := true
+		if len(f) > 0 {
+			v = f[0]
+		}
+		o.strict = v
+	})
+}
+
 // Env implements a Loader, that uses environment variables to retrieve
 // configuration values.
 //
+// Apart from the builtin types, the following types of the standard library
+// are supported: net.IP, net.IPMask, net.IPNet, netip.Addr, netip.Prefix,
+// time.Duration, time.Time (see WithTimeLayout), time.Location, url.URL,
+// regexp.Regexp and os.FileMode (in octal notation). Furthermore values are
+// converted to types implementing encoding.TextUnmarshaler, json.Unmarshaler
+// or flag.Value, which includes elements of slices and maps. However fields of
+// structs implementing only json.Unmarshaler are populated individually like
+// any other nested struct, unless the "json" option of the tag is set.
+// Fields of pointer type are only allocated and set, if the environment
+// variable is present, and remain nil otherwise.
+//
+// Fields can be marked as required using the "required" option, see Load for
+// details.
+//
+// Slices and maps are split at "," and entries of maps at the first "=",
+// which can be changed using WithSliceDelimiter and WithMapDelimiters or the
+// "sep" and "kvsep" options of the tag. Alternatively values can be decoded
+// as JSON, see DecodeJSON.
+//
+// Slices of structs are populated from indexed environment variables, e.g.
+// MYAPP_BACKENDS_0_HOST and MYAPP_BACKENDS_1_HOST for a field of type
+// []Backend with the key BACKENDS. The keys of the fields of elements
+// are determined by their tags or ComputeEnvKey relative to the element.
+// The indices have to start at 0 and be contiguous, otherwise an error is
+// returned.
+// Similarly maps with string keys and structs as elements are populated from
+// environment variables sharing the key of the field as prefix, e.g.
+// MYAPP_DATABASES_PRIMARY_HOST results in the map key "primary". Map keys are
+// always lowercased. Maps of other types can be collected this way using the
+// "collect" option of the tag, e.g. MYAPP_LABELS_TIER=web for the tag
+// `env:"LABELS,collect"`.
+//
+// Values can also be read from files referenced by variables with the suffix
+// "_FILE", see SupportFileSuffix.
+//
+// By default the environment of the process is used, which can be replaced
+// using FromMap, FromEnviron or WithLookup.
+//
 // Standalone usage example:
 //  cfg := struct{ // Illustrating some ways to load bytes from env
 //		A []byte `env:"NOPREFIX_A,noprefix"`
@@ -75,56 +340,286 @@ func OverrideEnvTag(tag string) EnvOption {
 //  }{}
 //  err := Env(WithPrefix("MYPREFIX"), ComputeEnvKey(UpperSnakeCase)).Process(&cfg)
 func Env(opts ...EnvOption) Loader {
-	o := envOptions{
-		tag:       "env",
-		prefix:    "",
-		keyGetter: func(s []string) string { return "" },
-	}
+	o := defaultEnvOptions()
 	for _, opt := range opts {
 		opt.apply(&o)
 	}
-	return LoaderFunc(func(dst interface{}) error {
-		return StructWalk(dst, func(path []string, field reflect.StructField) (interface{}, error) {
-			noPrefix := false
-			key := o.keyGetter(path)
-			targetType := field.Type
-			if tag, ok := field.Tag.Lookup(o.tag); ok {
-				params := strings.Split(tag, ",")
-				// Only set key if provided
-				if params[0] != "" {
-					key = params[0]
-				}
+	c := o.converter()
+	return trackedLoaderFunc(func(dst interface{}, t *tracker) error {
+		if !o.strict || o.prefix == "" {
+			return envWalk(dst, &o, c, t)
+		}
+		if o.environ == nil {
+			return errors.New("StrictPrefix requires enumerable environment variables, which WithLookup does not provide")
+		}
+		// The candidates of the tracker are the variables mapping to fields
+		if t == nil {
+			t = newTracker()
+		}
+		err := envWalk(dst, &o, c, t)
+		if err != nil && !t.collecting() {
+			return err
+		}
+		if unknownErr := unknownEnvVars(&o, t); unknownErr != nil {
+			if !t.collecting() {
+				return unknownErr
+			}
+			// Fields are still populated, if errors are collected
+			errs := []error{}
+			if err != nil {
+				errs = errorList(err)
+			}
+			return &MultiError{Errors: append(errs, unknownErr)}
+		}
+		return err
+	})
+}
+
+// unknownEnvVars returns an *UnknownEnvVarsError, if variables carrying the
+// prefix are not among the candidates recorded by t.
+func unknownEnvVars(o *envOptions, t *tracker) error {
+	known := []string{}
+	isKnown := map[string]bool{}
+	for _, srcs := range t.candidates {
+		for _, src := range srcs {
+			if src.Kind == SourceEnv && !isKnown[src.Name] {
+				known = append(known, src.Name)
+				isKnown[src.Name] = true
+			}
+		}
+	}
+	sort.Strings(known)
+	unknown := []UnknownEnvVar{}
+	for _, env := range o.variables() {
+		name := strings.SplitN(env, "=", 2)[0]
+		if !strings.HasPrefix(name, o.prefix+"_") || isKnown[name] {
+			continue
+		}
+		unknown = append(unknown, UnknownEnvVar{Name: name, Suggestion: suggestKey(name, known)})
+	}
+	if len(unknown) == 0 {
+		return nil
+	}
+	sort.Slice(unknown, func(i, j int) bool { return unknown[i].Name < unknown[j].Name })
+	return &UnknownEnvVarsError{Vars: unknown}
+}
+
+// maxSuggestionDistance is the maximum edit distance of suggestions.
+const maxSuggestionDistance = 3
+
+// suggestKey returns the candidate with the smallest edit distance to key,
+// unless it exceeds maxSuggestionDistance.
+func suggestKey(key string, candidates []string) string {
+	suggestion, min := "", maxSuggestionDistance+1
+	for _, c := range candidates {
+		if d := levenshtein(key, c); d < min {
+			suggestion, min = c, d
+		}
+	}
+	return suggestion
+}
+
+// levenshtein returns the edit distance of a and b.
+func levenshtein(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = minInt(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
 
-				if len(params) > 1 { // If options are set, let's handle them
-					for _, param := range params[1:] {
-						// Check options for byte arrays
-						if param == "hex" || param == "base64" {
-							if targetType.Kind() != reflect.Slice || targetType.Elem().Kind() != reflect.Uint8 {
-								return nil, fmt.Errorf("unsupported option '%s' for type '%s'", params[1], targetType.String())
+func minInt(values ...int) int {
+	m := values[0]
+	for _, v := range values[1:] {
+		if v < m {
+			m = v
+		}
+	}
+	return m
+}
+
+// envWalk populates the fields of dst from environment variables as
+// configured by o.
+func envWalk(dst interface{}, o *envOptions, c *converter, t *tracker) error {
+	return structWalk(dst, func(path []string, field reflect.StructField) (interface{}, error) {
+		noPrefix := false
+		collect := false
+		fileSuffix := o.fileSuffix
+		key := o.keyGetter(path)
+		targetType := field.Type
+		fc := c
+		decodeJSON := o.decodeJSON && c.isJSONType(field.Type)
+		if tag, ok := field.Tag.Lookup(o.tag); ok {
+			params := strings.Split(tag, ",")
+			// Only set key if provided
+			if params[0] != "" {
+				key = params[0]
+			}
+
+			if len(params) > 1 { // If options are set, let's handle them
+				for _, param := range params[1:] {
+					// Check options for byte arrays
+					if param == "hex" || param == "base64" {
+						if targetType.Kind() != reflect.Slice || targetType.Elem().Kind() != reflect.Uint8 {
+							return nil, &FieldError{
+								Path:       strings.Join(path, "."),
+								TargetType: field.Type,
+								Err:        fmt.Errorf("unsupported option '%s' for type '%s'", param, targetType.String()),
+							}
+						}
+						if param == "hex" {
+							targetType = reflect.TypeOf(convertBytesHexMarker{})
+						} else if param == "base64" {
+							targetType = reflect.TypeOf(convertBytesBase64Marker{})
+						}
+					}
+					if param == "noprefix" {
+						noPrefix = true
+					}
+					if param == "required" {
+						t.require(path)
+					}
+					if param == "json" {
+						decodeJSON = true
+					}
+					if param == "file" {
+						fileSuffix = true
+					}
+					if param == "collect" {
+						if !isCollectableMap(field.Type) {
+							return nil, &FieldError{
+								Path:       strings.Join(path, "."),
+								TargetType: field.Type,
+								Err:        fmt.Errorf("unsupported option '%s' for type '%s'", param, field.Type.String()),
 							}
-							if param == "hex" {
-								targetType = reflect.TypeOf(convertBytesHexMarker{})
-							} else if param == "base64" {
-								targetType = reflect.TypeOf(convertBytesBase64Marker{})
+						}
+						collect = true
+					}
+					// Delimiters can be overridden for individual fields
+					if strings.HasPrefix(param, "sep=") || strings.HasPrefix(param, "kvsep=") {
+						if fc == c {
+							cc := *c
+							fc = &cc
+						}
+						delim := param[strings.Index(param, "=")+1:]
+						if delim == "" {
+							return nil, &FieldError{
+								Path:       strings.Join(path, "."),
+								TargetType: field.Type,
+								Err:        fmt.Errorf("empty delimiter in option '%s'", param),
 							}
 						}
-						if param == "noprefix" {
-							noPrefix = true
+						if strings.HasPrefix(param, "sep=") {
+							fc.sliceDelim, fc.mapDelim = delim, delim
+						} else {
+							fc.kvDelim = delim
 						}
 					}
 				}
 			}
+		}
 
-			if o.prefix != "" && !noPrefix {
-				key = fmt.Sprintf("%s_%s", o.prefix, key)
-			}
+		if key == "" {
+			return nil, nil
+		}
+		if o.prefix != "" && !noPrefix {
+			key = fmt.Sprintf("%s_%s", o.prefix, key)
+		}
+		t.consider(path, Source{Kind: SourceEnv, Name: key})
 
-			if val, ok := os.LookupEnv(key); ok {
-				return convertString(targetType, val)
+		// Slices and maps of structs are populated from multiple environment
+		// variables, unless decoded as JSON
+		structSlice := c.isStructSlice(field.Type)
+		structMap := c.isStructMap(field.Type)
+		src := Source{Kind: SourceEnv, Name: key}
+		val, ok := o.lookup(key)
+		rawValue, contentsOf := val, ""
+		if fileSuffix {
+			fileSrc := Source{Kind: SourceEnv, Name: key + "_FILE"}
+			t.consider(path, fileSrc)
+			if filePath, fileOk := o.lookup(fileSrc.Name); fileOk {
+				if ok {
+					return nil, &FieldError{
+						Path:       strings.Join(path, "."),
+						Source:     fileSrc,
+						TargetType: field.Type,
+						Err:        fmt.Errorf("both '%s' and '%s' are set", key, fileSrc.Name),
+					}
+				}
+				data, err := ioutil.ReadFile(filePath)
+				if err != nil {
+					return nil, &FieldError{
+						Path:       strings.Join(path, "."),
+						Source:     fileSrc,
+						RawValue:   filePath,
+						TargetType: field.Type,
+						Err:        err,
+					}
+				}
+				val, ok, src = string(data), true, fileSrc
+				if !o.keepNL {
+					val = strings.TrimSuffix(strings.TrimSuffix(val, "\n"), "\r")
+				}
+				// The contents of files are usually secrets, so let's not
+				// expose them in errors
+				rawValue, contentsOf = "", filePath
 			}
-			return nil, nil
-		})
-	})
+		}
+		if ok && (decodeJSON || !(structSlice || structMap || collect)) {
+			var (
+				v   interface{}
+				err error
+			)
+			if decodeJSON {
+				v, err = convertJSON(field.Type, val)
+			} else {
+				v, err = fc.convert(targetType, val)
+			}
+			// Conversion errors commonly include the input
+			if err != nil && contentsOf != "" && !errors.Is(err, ErrUnsupportedType) {
+				err = fmt.Errorf("unable to parse the contents of '%s' as %s", contentsOf, field.Type)
+			}
+			if err != nil {
+				return nil, &FieldError{
+					Path:       strings.Join(path, "."),
+					Source:     src,
+					RawValue:   rawValue,
+					TargetType: field.Type,
+					Err:        err,
+				}
+			}
+			t.track(path, src)
+			return v, nil
+		}
+		switch {
+		case structSlice:
+			return envIndexed(path, field.Type, key, o, c, t)
+		case structMap || collect:
+			return envCollected(path, field.Type, key, structMap, o, fc, t)
+		}
+		return nil, nil
+	}, walkOptions{collectErrors: t.collecting(), isLeaf: o.isLeaf(c)})
+}
+
+// isLeaf returns a function reporting whether a field is populated as a whole
+// rather than walked into, see converter.isLeaf.
+func (o *envOptions) isLeaf(c *converter) func(reflect.StructField) bool {
+	return func(field reflect.StructField) bool {
+		// Nested structs decoded as JSON are populated as a whole
+		return c.isLeaf(field) || hasTagParam(field, o.tag, "json")
+	}
 }
 
 const (
@@ -137,11 +632,57 @@ type convertBytesHexMarker []byte
 
 type convertBytesBase64Marker []byte
 
-// Converts `input` string to type `t` or returns error if operation is not
-// possible. Type `t` needs to be NOT a pointer kind!
+// DecodeFunc converts a string to a value of a specific type, see WithDecoder.
+type DecodeFunc func(input string) (interface{}, error)
+
+// converter converts strings to values of arbitrary types.
+type converter struct {
+	decoders   map[reflect.Type]DecodeFunc
+	timeLayout string
+	sliceDelim string
+	mapDelim   string
+	kvDelim    string
+}
+
+// Converts `input` string to type `t` using the default converter.
 func convertString(t reflect.Type, input string) (interface{}, error) {
+	o := defaultEnvOptions()
+	return o.converter().convert(t, input)
+}
+
+// Converts `input` string to type `t` or returns error if operation is not
+// possible.
+func (c *converter) convert(t reflect.Type, input string) (interface{}, error) {
+	// Registered decoders take precedence over everything else
+	if decode, ok := c.decoders[t]; ok {
+		v, err := decode(input)
+		if err != nil {
+			return nil, err
+		}
+		vv := reflect.ValueOf(v)
+		if !vv.IsValid() {
+			return reflect.Zero(t).Interface(), nil
+		}
+		if !vv.Type().AssignableTo(t) {
+			return nil, fmt.Errorf("%w: decoder for type '%s' returned '%T'", ErrTypeMismatch, t.String(), v)
+		}
+		return v, nil
+	}
+
+	// Handle supported types of the standard library commonly used as pointers
+	if v, ok, err := convertStdlibPointer(t, input); ok {
+		return v, err
+	}
+
+	// Pointers are allocated and their element converted
 	if t.Kind() == reflect.Ptr {
-		return nil, fmt.Errorf("no pointer kinds allowed")
+		v, err := c.convert(t.Elem(), input)
+		if err != nil {
+			return nil, err
+		}
+		ptr := reflect.New(t.Elem())
+		ptr.Elem().Set(reflect.ValueOf(v))
+		return ptr.Interface(), nil
 	}
 
 	// Let's handle the marker structs
@@ -166,7 +707,7 @@ func convertString(t reflect.Type, input string) (interface{}, error) {
 		case "IPMask":
 			ipMask := pflag.ParseIPv4Mask(input)
 			if ipMask == nil {
-				return nil, fmt.Errorf("unable to parse '%s' as net.IPMast", input)
+				return nil, fmt.Errorf("unable to parse '%s' as net.IPMask", input)
 			}
 			return ipMask, nil
 		case "IPNet":
@@ -179,13 +720,58 @@ func convertString(t reflect.Type, input string) (interface{}, error) {
 		}
 	}
 
-	// Parse time.Duration
-	if t.PkgPath() == "time" && t.Name() == "Duration" {
-		d, err := time.ParseDuration(input)
+	// Handle supported net/netip-types
+	if t.PkgPath() == "net/netip" {
+		switch t.Name() {
+		case "Addr":
+			addr, err := netip.ParseAddr(input)
+			if err != nil {
+				return nil, fmt.Errorf("unable to parse '%s' as netip.Addr, failed with: %w", input, err)
+			}
+			return addr, nil
+		case "Prefix":
+			prefix, err := netip.ParsePrefix(input)
+			if err != nil {
+				return nil, fmt.Errorf("unable to parse '%s' as netip.Prefix, failed with: %w", input, err)
+			}
+			return prefix, nil
+		}
+	}
+
+	// Handle supported time-types
+	if t.PkgPath() == "time" {
+		switch t.Name() {
+		case "Duration":
+			d, err := time.ParseDuration(input)
+			if err != nil {
+				return nil, fmt.Errorf("unable to parse '%s' as time.Duration, failed with: %w", input, err)
+			}
+			return d, nil
+		case "Time":
+			layout := c.timeLayout
+			if layout == "" {
+				layout = time.RFC3339
+			}
+			tt, err := time.Parse(layout, input)
+			if err != nil {
+				return nil, fmt.Errorf("unable to parse '%s' as time.Time, failed with: %w", input, err)
+			}
+			return tt, nil
+		}
+	}
+
+	// Parse os.FileMode in octal notation, e.g. "0644"
+	if t == reflect.TypeOf(os.FileMode(0)) {
+		m, err := strconv.ParseUint(input, 8, 32)
 		if err != nil {
-			return nil, fmt.Errorf("unable to parse '%s' as time.Duration, failed with: %w", input, err)
+			return nil, fmt.Errorf("unable to parse '%s' as os.FileMode, failed with: %w", input, err)
 		}
-		return d, nil
+		return os.FileMode(m), nil
+	}
+
+	// Types that know how to unmarshal themselves take precedence over their kind
+	if isUnmarshaler(t) {
+		return convertUnmarshaler(t, input)
 	}
 
 	// Convert to in-built types
@@ -205,10 +791,13 @@ func convertString(t reflect.Type, input string) (interface{}, error) {
 	case reflect.Float32, reflect.Float64:
 		v, err = strconv.ParseFloat(input, t.Bits())
 	case reflect.Slice:
-		elems := strings.Split(input, arrayDelimiter)
+		elems, err := splitEscaped(input, c.sliceDelim, "", -1, true)
+		if err != nil {
+			return nil, err
+		}
 		values := reflect.MakeSlice(t, len(elems), len(elems))
 		for i, elem := range elems {
-			convertedValue, err := convertString(t.Elem(), elem)
+			convertedValue, err := c.convert(t.Elem(), elem)
 			if err != nil {
 				return nil, err
 			}
@@ -217,20 +806,30 @@ func convertString(t reflect.Type, input string) (interface{}, error) {
 		return values.Interface(), nil
 	case reflect.Map:
 		values := reflect.MakeMap(t)
-		keyValues := strings.Split(input, mapDelimiter)
+		// Escapes and quotes are kept when splitting the entries, so they
+		// apply to the key-value delimiter as well
+		keyValues, err := splitEscaped(input, c.mapDelim, c.kvDelim, -1, false)
+		if err != nil {
+			return nil, err
+		}
 		for _, keyValueUnsplit := range keyValues {
-			keyValue := strings.Split(keyValueUnsplit, mapKVDelimiter)
+			// Entries only contain escaped or quoted entry delimiters, so
+			// passing it as kvDelim merely removes the escaping backslashes
+			keyValue, err := splitEscaped(keyValueUnsplit, c.kvDelim, c.mapDelim, 2, true)
+			if err != nil {
+				return nil, err
+			}
 			if len(keyValue) != 2 {
 				return nil, fmt.Errorf("invalid key value item provided: %s", keyValueUnsplit)
 			}
 			key := reflect.New(t.Key()).Elem()
-			keyData, err := convertString(key.Type(), keyValue[0])
+			keyData, err := c.convert(key.Type(), keyValue[0])
 			if err != nil {
 				return nil, err
 			}
 			key.Set(reflect.ValueOf(keyData))
 			value := reflect.New(t.Elem()).Elem()
-			valueData, err := convertString(value.Type(), keyValue[1])
+			valueData, err := c.convert(value.Type(), keyValue[1])
 			if err != nil {
 				return nil, err
 			}
@@ -239,7 +838,7 @@ func convertString(t reflect.Type, input string) (interface{}, error) {
 		}
 		return values.Interface(), nil
 	default:
-		return nil, fmt.Errorf("unsupported type: %s", t.Kind().String())
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedType, t.Kind().String())
 	}
 
 	if err != nil {
@@ -252,3 +851,443 @@ func convertString(t reflect.Type, input string) (interface{}, error) {
 	vv = vv.Convert(t)
 	return vv.Interface(), nil
 }
+
+// splitEscaped slices s into the substrings separated by delim. Delimiters
+// escaped by a backslash or enclosed in double quotes are not split at.
+// A double quote only starts a quoted section at the beginning of a
+// substring or, if kvDelim is set, directly after the first kvDelim of a
+// substring, so the values of map entries can be quoted as well. Otherwise
+// it is retained as is. Unterminated quoted sections result in an error.
+// A backslash only escapes the delimiters, a double quote or another
+// backslash, otherwise it is retained. If n is positive, at most n substrings
+// are returned and the last substring is the unsplit remainder.
+// If unquote is set, escaping backslashes and quotes are removed from the
+// substrings, otherwise they are retained.
+func splitEscaped(s, delim, kvDelim string, n int, unquote bool) ([]string, error) {
+	var (
+		parts  []string
+		cur    strings.Builder
+		quoted bool
+		// Whether a quote at the current position starts a quoted section
+		atStart = true
+		kvSeen  bool
+	)
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && i+1 < len(s) && strings.HasPrefix(s[i+1:], delim):
+			if !unquote {
+				cur.WriteByte('\\')
+			}
+			cur.WriteString(delim)
+			i += len(delim)
+			atStart = false
+		case s[i] == '\\' && kvDelim != "" && strings.HasPrefix(s[i+1:], kvDelim):
+			if !unquote {
+				cur.WriteByte('\\')
+			}
+			cur.WriteString(kvDelim)
+			i += len(kvDelim)
+			atStart = false
+		case s[i] == '\\' && i+1 < len(s) && (s[i+1] == '\\' || s[i+1] == '"'):
+			if !unquote {
+				cur.WriteByte('\\')
+			}
+			cur.WriteByte(s[i+1])
+			i++
+			atStart = false
+		case s[i] == '"' && (quoted || atStart):
+			quoted = !quoted
+			if !unquote {
+				cur.WriteByte('"')
+			}
+			atStart = false
+		case !quoted && (n <= 0 || len(parts) < n-1) && strings.HasPrefix(s[i:], delim):
+			parts = append(parts, cur.String())
+			cur.Reset()
+			i += len(delim) - 1
+			atStart, kvSeen = true, false
+		case !quoted && kvDelim != "" && !kvSeen && strings.HasPrefix(s[i:], kvDelim):
+			cur.WriteString(kvDelim)
+			i += len(kvDelim) - 1
+			atStart, kvSeen = true, true
+		default:
+			cur.WriteByte(s[i])
+			atStart = false
+		}
+	}
+	if quoted {
+		// The value is not included, as it might be a secret
+		return nil, fmt.Errorf("unterminated quote in element %d", len(parts)+1)
+	}
+	return append(parts, cur.String()), nil
+}
+
+// convertStdlibPointer converts input to url.URL, regexp.Regexp or
+// time.Location, which are usually used as pointers, so t can be either the
+// pointer or the value type. If t is neither, ok is false.
+func convertStdlibPointer(t reflect.Type, input string) (v interface{}, ok bool, err error) {
+	var ptr interface{}
+	switch indirectType(t) {
+	case reflect.TypeOf(url.URL{}):
+		ptr, err = url.Parse(input)
+	case reflect.TypeOf(regexp.Regexp{}):
+		ptr, err = regexp.Compile(input)
+	case reflect.TypeOf(time.Location{}):
+		ptr, err = time.LoadLocation(input)
+	default:
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, true, fmt.Errorf("unable to parse '%s' as %s, failed with: %w", input, t.String(), err)
+	}
+	if t.Kind() == reflect.Ptr {
+		return ptr, true, nil
+	}
+	return reflect.ValueOf(ptr).Elem().Interface(), true, nil
+}
+
+// indirectType returns the element type of t, if t is a pointer.
+func indirectType(t reflect.Type) reflect.Type {
+	if t.Kind() == reflect.Ptr {
+		return t.Elem()
+	}
+	return t
+}
+
+// isLeaf reports whether field should not be walked into, because a decoder
+// is registered for its type or the element type if it is a pointer.
+func (c *converter) isLeaf(field reflect.StructField) bool {
+	_, ok := c.decoders[field.Type]
+	_, okElem := c.decoders[indirectType(field.Type)]
+	return ok || okElem
+}
+
+// isJSONType reports whether values of type t are decoded as JSON if
+// DecodeJSON is set, see DecodeJSON for details.
+func (c *converter) isJSONType(t reflect.Type) bool {
+	if _, ok := c.decoders[t]; ok {
+		return false
+	}
+	t = indirectType(t)
+	if _, ok := c.decoders[t]; ok || isUnmarshaler(t) {
+		return false
+	}
+	switch t.Kind() {
+	case reflect.Slice:
+		return t.Elem().Kind() != reflect.Uint8
+	case reflect.Map, reflect.Array:
+		return true
+	}
+	return false
+}
+
+// convertJSON decodes input as JSON into a new value of type t.
+func convertJSON(t reflect.Type, input string) (interface{}, error) {
+	ptr := reflect.New(t)
+	if err := json.Unmarshal([]byte(input), ptr.Interface()); err != nil {
+		return nil, fmt.Errorf("unable to parse '%s' as JSON, failed with: %w", input, err)
+	}
+	return ptr.Elem().Interface(), nil
+}
+
+// isStructSlice reports whether t is a slice of structs or pointers to
+// structs, which are populated from indexed environment variables.
+func (c *converter) isStructSlice(t reflect.Type) bool {
+	if _, ok := c.decoders[t]; ok || t.Kind() != reflect.Slice {
+		return false
+	}
+	_, ok := c.decoders[t.Elem()]
+	// Elements unmarshalling themselves are converted from a single variable
+	return !ok && isNestedStruct(t.Elem()) && !isUnmarshaler(indirectType(t.Elem()))
+}
+
+// isStructMap reports whether t is a map with string keys and structs or
+// pointers to structs as elements, which are populated from environment
+// variables sharing a prefix.
+func (c *converter) isStructMap(t reflect.Type) bool {
+	if _, ok := c.decoders[t]; ok || !isCollectableMap(t) {
+		return false
+	}
+	_, ok := c.decoders[t.Elem()]
+	// Elements unmarshalling themselves are converted from a single variable
+	return !ok && isNestedStruct(t.Elem()) && !isUnmarshaler(indirectType(t.Elem()))
+}
+
+// envIndexed populates a slice of structs of type typ from environment
+// variables prefixed with key and the index of the element, e.g. KEY_0_HOST
+// and KEY_1_HOST. The indices present have to start at 0 and be contiguous,
+// so a mistyped index does not result in zero-valued elements.
+func envIndexed(path []string, typ reflect.Type, key string, o *envOptions, c *converter, t *tracker) (interface{}, error) {
+	n := 0
+	indices := map[int]bool{}
+	for _, env := range o.variables() {
+		name := strings.SplitN(env, "=", 2)[0]
+		if i, ok := envIndex(name, key); ok {
+			indices[i] = true
+			if i >= n {
+				n = i + 1
+			}
+		}
+	}
+	if n == 0 {
+		return nil, nil
+	}
+	for i := 0; i < n; i++ {
+		if !indices[i] {
+			return nil, &FieldError{
+				Path:       strings.Join(append(path[:len(path):len(path)], strconv.Itoa(i)), "."),
+				Source:     Source{Kind: SourceEnv, Name: fmt.Sprintf("%s_%d", key, i)},
+				TargetType: typ.Elem(),
+				Err:        fmt.Errorf("missing index %d of '%s', indices have to be contiguous", i, key),
+			}
+		}
+	}
+	values := reflect.MakeSlice(typ, n, n)
+	var errs []error
+	for i := 0; i < n; i++ {
+		elemPath := append(path[:len(path):len(path)], strconv.Itoa(i))
+		elem, err := envElement(elemPath, typ.Elem(), fmt.Sprintf("%s_%d", key, i), o, c, t)
+		if err != nil {
+			if !t.collecting() {
+				return nil, err
+			}
+			errs = append(errs, errorList(err)...)
+			continue
+		}
+		values.Index(i).Set(elem)
+	}
+	if len(errs) > 0 {
+		return nil, &MultiError{Errors: errs}
+	}
+	t.track(path, Source{Kind: SourceEnv, Name: key})
+	return values.Interface(), nil
+}
+
+// envElement returns a struct (or pointer to one) of type typ populated by
+// environment variables as if it was a standalone struct using prefix.
+// Paths of errors are prefixed with path.
+func envElement(path []string, typ reflect.Type, prefix string, o *envOptions, c *converter, t *tracker) (reflect.Value, error) {
+	elemOpts := *o
+	elemOpts.prefix = prefix
+	elemTracker := newTracker()
+	elemTracker.collectErrors = t.collecting()
+	elem := reflect.New(indirectType(typ))
+	err := envWalk(elem.Interface(), &elemOpts, c, elemTracker)
+	// The variables of elements could have populated the field as well
+	for key, srcs := range elemTracker.candidates {
+		for _, src := range srcs {
+			t.consider(append(path[:len(path):len(path)], key), src)
+		}
+	}
+	if err != nil {
+		for _, e := range errorList(err) {
+			var fieldErr *FieldError
+			if errors.As(e, &fieldErr) {
+				fieldErr.Path = strings.Join(append(path[:len(path):len(path)], fieldErr.Path), ".")
+			}
+		}
+		return reflect.Value{}, err
+	}
+	if typ.Kind() == reflect.Ptr {
+		return elem, nil
+	}
+	return elem.Elem(), nil
+}
+
+// isCollectableMap reports whether t is a map with string keys, which can be
+// collected from environment variables sharing a prefix.
+func isCollectableMap(t reflect.Type) bool {
+	return t.Kind() == reflect.Map && t.Key().Kind() == reflect.String
+}
+
+// envCollected populates a map of type typ from environment variables
+// prefixed with key. For example KEY_PRIMARY results in the map key "primary".
+// If structs is set, the elements are structs, which are populated from
+// variables prefixed with the key of the element instead, e.g.
+// KEY_PRIMARY_HOST.
+func envCollected(path []string, typ reflect.Type, key string, structs bool, o *envOptions, c *converter, t *tracker) (interface{}, error) {
+	var suffixes []string
+	if structs {
+		suffixes = envKeys(indirectType(typ.Elem()), o, c)
+	}
+	names := []string{}
+	for _, env := range o.variables() {
+		names = append(names, strings.SplitN(env, "=", 2)[0])
+	}
+	sort.Strings(names)
+	// Map keys are lowercased, but the original is required to retrieve values
+	rawKeys := map[string]string{}
+	mapKeys := []string{}
+	for _, name := range names {
+		if !strings.HasPrefix(name, key+"_") {
+			continue
+		}
+		rest := strings.TrimPrefix(name, key+"_")
+		if structs {
+			rest = envMapKey(rest, suffixes)
+		}
+		if rest == "" {
+			continue
+		}
+		if _, ok := rawKeys[strings.ToLower(rest)]; !ok {
+			rawKeys[strings.ToLower(rest)] = rest
+			mapKeys = append(mapKeys, strings.ToLower(rest))
+		}
+	}
+	if len(mapKeys) == 0 {
+		return nil, nil
+	}
+	sort.Strings(mapKeys)
+
+	values := reflect.MakeMapWithSize(typ, len(mapKeys))
+	var errs []error
+	for _, mapKey := range mapKeys {
+		elemPath := append(path[:len(path):len(path)], mapKey)
+		elemKey := key + "_" + rawKeys[mapKey]
+		var (
+			elem reflect.Value
+			err  error
+		)
+		if structs {
+			elem, err = envElement(elemPath, typ.Elem(), elemKey, o, c, t)
+		} else {
+			t.consider(elemPath, Source{Kind: SourceEnv, Name: elemKey})
+			val, _ := o.lookup(elemKey)
+			var v interface{}
+			if v, err = c.convert(typ.Elem(), val); err != nil {
+				err = &FieldError{
+					Path:       strings.Join(elemPath, "."),
+					Source:     Source{Kind: SourceEnv, Name: elemKey},
+					RawValue:   val,
+					TargetType: typ.Elem(),
+					Err:        err,
+				}
+			} else {
+				elem = reflect.ValueOf(v)
+			}
+		}
+		if err != nil {
+			if !t.collecting() {
+				return nil, err
+			}
+			errs = append(errs, errorList(err)...)
+			continue
+		}
+		k := reflect.New(typ.Key()).Elem()
+		k.SetString(mapKey)
+		values.SetMapIndex(k, elem)
+	}
+	if len(errs) > 0 {
+		return nil, &MultiError{Errors: errs}
+	}
+	t.track(path, Source{Kind: SourceEnv, Name: key})
+	return values.Interface(), nil
+}
+
+// envKeys returns the keys of the fields of a struct of type typ relative to
+// its prefix. Keys of fields, that are collected from multiple variables,
+// e.g. slices of structs, end with "_" as they only prefix actual variables.
+func envKeys(typ reflect.Type, o *envOptions, c *converter) []string {
+	keys := []string{}
+	_ = structWalk(reflect.New(typ).Interface(), func(path []string, field reflect.StructField) (interface{}, error) {
+		key := o.keyGetter(path)
+		if name := strings.Split(field.Tag.Get(o.tag), ",")[0]; name != "" {
+			key = name
+		}
+		if key == "" || hasTagParam(field, o.tag, "noprefix") {
+			return nil, nil
+		}
+		if c.isStructSlice(field.Type) || isCollectableMap(field.Type) {
+			key += "_"
+		}
+		keys = append(keys, key)
+		return nil, nil
+	}, walkOptions{isLeaf: o.isLeaf(c)})
+	return keys
+}
+
+// envMapKey returns the map key of the variable name rest, that is relative to
+// the prefix of the map, by removing one of the keys of the struct fields.
+// If no key matches, an empty string is returned.
+func envMapKey(rest string, keys []string) string {
+	mapKey := ""
+	for _, key := range keys {
+		var k string
+		if strings.HasSuffix(key, "_") {
+			if i := strings.Index(rest, "_"+key); i > 0 {
+				k = rest[:i]
+			}
+		} else if strings.HasSuffix(rest, "_"+key) {
+			k = strings.TrimSuffix(rest, "_"+key)
+		}
+		// Prefer the shortest map key, i.e. the longest field key
+		if k != "" && (mapKey == "" || len(k) < len(mapKey)) {
+			mapKey = k
+		}
+	}
+	return mapKey
+}
+
+// envIndex returns the index of an environment variable name, if it is
+// prefixed with key followed by an index, e.g. KEY_0_HOST.
+func envIndex(name, key string) (int, bool) {
+	rest := strings.TrimPrefix(name, key+"_")
+	end := strings.Index(rest, "_")
+	if rest == name || end <= 0 {
+		return 0, false
+	}
+	// Indices are limited to reasonable slice lengths
+	i, err := strconv.ParseUint(rest[:end], 10, 16)
+	if err != nil {
+		return 0, false
+	}
+	return int(i), true
+}
+
+// hasTagParam reports whether the value of tag of field contains param as an
+// option, i.e. after the first comma.
+func hasTagParam(field reflect.StructField, tag, param string) bool {
+	params := strings.Split(field.Tag.Get(tag), ",")
+	for _, p := range params[1:] {
+		if p == param {
+			return true
+		}
+	}
+	return false
+}
+
+// convertUnmarshaler converts input to type t using the first interface
+// implemented by a pointer to t: encoding.TextUnmarshaler, json.Unmarshaler
+// or flag.Value. As json.Unmarshaler expects JSON, input is passed as is if
+// it is a valid JSON object, array or string and otherwise quoted as JSON
+// string. If the quoted input is rejected, other valid JSON, e.g. numbers, is
+// passed as is instead.
+func convertUnmarshaler(t reflect.Type, input string) (interface{}, error) {
+	ptr := reflect.New(t)
+	var err error
+	switch u := ptr.Interface().(type) {
+	case encoding.TextUnmarshaler:
+		err = u.UnmarshalText([]byte(input))
+	case json.Unmarshaler:
+		raw := []byte(input)
+		valid := json.Valid(raw)
+		if valid && strings.ContainsAny(input[:1], "{[\"") {
+			err = u.UnmarshalJSON(raw)
+			break
+		}
+		quoted, _ := json.Marshal(input)
+		if err = u.UnmarshalJSON(quoted); err != nil && valid {
+			// The value might be partially set by the failed attempt
+			retry := reflect.New(t)
+			if retry.Interface().(json.Unmarshaler).UnmarshalJSON(raw) == nil {
+				ptr, err = retry, nil
+			}
+		}
+	case flag.Value:
+		err = u.Set(input)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse '%s' as %s, failed with: %w", input, t.String(), err)
+	}
+	return ptr.Elem().Interface(), nil
+}
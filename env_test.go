@@ -1,9 +1,17 @@
 package copre
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
 	"net"
+	"net/netip"
+	"net/url"
 	"os"
+	"path/filepath"
 	"reflect"
+	"regexp"
+	"strings"
 	"testing"
 	"time"
 
@@ -131,6 +139,76 @@ func TestConvertNetTypes(t *testing.T) {
 	}
 }
 
+func TestConvertNetipTypes(t *testing.T) {
+	assert := assert.New(t)
+	conversions := map[string]interface{}{
+		"127.0.0.1":      netip.MustParseAddr("127.0.0.1"),
+		"::1":            netip.MustParseAddr("::1"),
+		"192.168.0.0/16": netip.MustParsePrefix("192.168.0.0/16"),
+	}
+	for input, expected := range conversions {
+		t.Logf("converting to %T", expected)
+		converted, err := convertString(reflect.TypeOf(expected), input)
+		assert.NoError(err)
+		assert.Equal(expected, converted)
+	}
+	_, err := convertString(reflect.TypeOf(netip.Addr{}), "localhost")
+	assert.Error(err)
+}
+
+func TestConvertStdlibTypes(t *testing.T) {
+	assert := assert.New(t)
+	u, _ := url.Parse("https://example.com/path?query=1")
+	loc, _ := time.LoadLocation("Europe/Berlin")
+	conversions := map[string]interface{}{
+		"2021-10-16T12:00:00Z":             time.Date(2021, 10, 16, 12, 0, 0, 0, time.UTC),
+		"https://example.com/path?query=1": u,
+		"Europe/Berlin":                    loc,
+		"^[a-z]+$":                         regexp.MustCompile("^[a-z]+$"),
+		"0644":                             os.FileMode(0644),
+	}
+	for input, expected := range conversions {
+		t.Logf("converting to %T", expected)
+		converted, err := convertString(reflect.TypeOf(expected), input)
+		assert.NoError(err)
+		assert.Equal(expected, converted)
+		// Types used as pointers are also supported as values
+		if reflect.TypeOf(expected).Kind() == reflect.Ptr {
+			converted, err = convertString(reflect.TypeOf(expected).Elem(), input)
+			assert.NoError(err)
+			assert.Equal(reflect.ValueOf(expected).Elem().Interface(), converted)
+		}
+	}
+	for _, expected := range []interface{}{time.Time{}, &url.URL{}, &time.Location{}, &regexp.Regexp{}, os.FileMode(0)} {
+		_, err := convertString(reflect.TypeOf(expected), "%[9")
+		assert.Error(err)
+	}
+}
+
+func TestEnvStdlibTypes(t *testing.T) {
+	require := require.New(t)
+	os.Setenv("ENV_STDLIB_TYPES_CREATED", "16.10.2021")
+	os.Setenv("ENV_STDLIB_TYPES_ENDPOINT", "https://example.com")
+	os.Setenv("ENV_STDLIB_TYPES_PATTERN", "^a$")
+	os.Setenv("ENV_STDLIB_TYPES_ADDRS", "127.0.0.1,::1")
+	result := struct {
+		Created  time.Time
+		Endpoint *url.URL
+		Pattern  *regexp.Regexp
+		Addrs    []netip.Addr
+	}{}
+	err := Env(
+		WithPrefix("ENV_STDLIB_TYPES"),
+		ComputeEnvKey(UpperSnakeCase),
+		WithTimeLayout("02.01.2006"),
+	).Process(&result)
+	require.NoError(err)
+	require.Equal(time.Date(2021, 10, 16, 0, 0, 0, 0, time.UTC), result.Created)
+	require.Equal("https://example.com", result.Endpoint.String())
+	require.True(result.Pattern.MatchString("a"))
+	require.Equal([]netip.Addr{netip.MustParseAddr("127.0.0.1"), netip.MustParseAddr("::1")}, result.Addrs)
+}
+
 func TestConvertTimeDuration(t *testing.T) {
 	assert := assert.New(t)
 	conversions := map[string]interface{}{
@@ -168,3 +246,611 @@ func TestConvertErrors(t *testing.T) {
 		assert.Error(err)
 	}
 }
+
+type testLogLevel int
+
+func (l *testLogLevel) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "debug":
+		*l = 0
+	case "info":
+		*l = 1
+	default:
+		return fmt.Errorf("unknown log level '%s'", text)
+	}
+	return nil
+}
+
+type testVersion struct {
+	Major, Minor int
+}
+
+func (v *testVersion) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	_, err := fmt.Sscanf(s, "v%d.%d", &v.Major, &v.Minor)
+	return err
+}
+
+// testRevision only accepts JSON strings, e.g. "2"
+type testRevision string
+
+func (r *testRevision) UnmarshalJSON(data []byte) error {
+	return json.Unmarshal(data, (*string)(r))
+}
+
+// testBuild only accepts JSON numbers, e.g. 42
+type testBuild int
+
+func (b *testBuild) UnmarshalJSON(data []byte) error {
+	return json.Unmarshal(data, (*int)(b))
+}
+
+type testFlagValue struct {
+	Value string
+}
+
+func (v *testFlagValue) String() string {
+	return v.Value
+}
+
+func (v *testFlagValue) Set(s string) error {
+	v.Value = strings.ToUpper(s)
+	return nil
+}
+
+func TestConvertUnmarshalers(t *testing.T) {
+	assert := assert.New(t)
+	conversions := map[string]interface{}{
+		"info":        testLogLevel(1),
+		"debug,info":  []testLogLevel{0, 1},
+		"a=info":      map[string]testLogLevel{"a": 1},
+		"v1.2":        testVersion{Major: 1, Minor: 2},
+		`"v1.2"`:      testVersion{Major: 1, Minor: 2},
+		"v1.2,v3.4":   []testVersion{{1, 2}, {3, 4}},
+		"2":           testRevision("2"),
+		"true":        testRevision("true"),
+		"null":        testRevision("null"),
+		"42":          testBuild(42),
+		"foo":         testFlagValue{Value: "FOO"},
+		"foo=bar,a=b": map[string]testFlagValue{"foo": {"BAR"}, "a": {"B"}},
+	}
+	for input, expected := range conversions {
+		t.Logf("converting to %T", expected)
+		converted, err := convertString(reflect.TypeOf(expected), input)
+		assert.NoError(err)
+		assert.Equal(expected, converted)
+	}
+	_, err := convertString(reflect.TypeOf(testLogLevel(0)), "trace")
+	assert.Error(err)
+}
+
+func TestEnvUnmarshalers(t *testing.T) {
+	require := require.New(t)
+	os.Setenv("ENV_UNMARSHALERS_LEVEL", "info")
+	os.Setenv("ENV_UNMARSHALERS_VERSIONS", "v1.2,v3.4")
+	os.Setenv("ENV_UNMARSHALERS_NETWORK", "10.0.0.0/8")
+	result := struct {
+		Level    testLogLevel
+		Versions []testVersion
+		Network  net.IPNet
+	}{}
+	err := Env(WithPrefix("ENV_UNMARSHALERS"), ComputeEnvKey(UpperSnakeCase)).Process(&result)
+	require.NoError(err)
+	require.Equal(testLogLevel(1), result.Level)
+	require.Equal([]testVersion{{Major: 1, Minor: 2}, {Major: 3, Minor: 4}}, result.Versions)
+	require.Equal("10.0.0.0/8", result.Network.String())
+}
+
+type testDBSection struct {
+	Host string
+	Port int
+}
+
+func (s *testDBSection) UnmarshalJSON(data []byte) error {
+	type plain testDBSection
+	return json.Unmarshal(data, (*plain)(s))
+}
+
+func TestEnvJSONUnmarshalerSection(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	env := map[string]string{
+		"MYAPP_DB_HOST": "db",
+		"MYAPP_DB_PORT": "5432",
+	}
+	result := struct {
+		DB testDBSection
+	}{}
+	err := Env(WithPrefix("MYAPP"), ComputeEnvKey(UpperSnakeCase), FromMap(env)).Process(&result)
+	require.NoError(err)
+	assert.Equal(testDBSection{Host: "db", Port: 5432}, result.DB)
+
+	paths := []string{}
+	err = StructWalk(&result, func(path []string, _ reflect.StructField) (interface{}, error) {
+		paths = append(paths, strings.Join(path, "."))
+		return nil, nil
+	})
+	require.NoError(err)
+	assert.Equal([]string{"DB.Host", "DB.Port"}, paths)
+}
+
+type testMoney struct {
+	Cents int64
+}
+
+func decodeTestMoney(s string) (interface{}, error) {
+	var euros, cents int64
+	if _, err := fmt.Sscanf(s, "%d.%d", &euros, &cents); err != nil {
+		return nil, err
+	}
+	return testMoney{Cents: euros*100 + cents}, nil
+}
+
+func TestEnvWithDecoder(t *testing.T) {
+	require := require.New(t)
+	os.Setenv("ENV_WITH_DECODER_PRICE", "1.50")
+	os.Setenv("ENV_WITH_DECODER_PRICES", "1.00,2.50")
+	os.Setenv("ENV_WITH_DECODER_LABEL", "label")
+	result := struct {
+		Price  testMoney
+		Prices []testMoney
+		Label  string
+	}{}
+	err := Env(
+		WithPrefix("ENV_WITH_DECODER"),
+		ComputeEnvKey(UpperSnakeCase),
+		WithDecoder(reflect.TypeOf(testMoney{}), decodeTestMoney),
+		WithDecoder(reflect.TypeOf(""), func(s string) (interface{}, error) {
+			return strings.ToUpper(s), nil
+		}),
+	).Process(&result)
+	require.NoError(err)
+	require.Equal(testMoney{Cents: 150}, result.Price)
+	require.Equal([]testMoney{{Cents: 100}, {Cents: 250}}, result.Prices)
+	require.Equal("LABEL", result.Label)
+
+	err = Env(
+		WithPrefix("ENV_WITH_DECODER"),
+		ComputeEnvKey(UpperSnakeCase),
+		WithDecoder(reflect.TypeOf(testMoney{}), decodeTestMoney),
+		WithDecoder(reflect.TypeOf(""), func(s string) (interface{}, error) {
+			return 1, nil
+		}),
+	).Process(&result)
+	require.True(errors.Is(err, ErrTypeMismatch))
+}
+
+func TestConvertPointers(t *testing.T) {
+	assert := assert.New(t)
+	i, b, s := 1, true, "1"
+	conversions := []interface{}{&i, &b, &s, []*int{&i}, map[string]*string{"1": &s}}
+	for _, expected := range conversions {
+		t.Logf("converting to %T", expected)
+		input := "1"
+		if reflect.TypeOf(expected).Kind() == reflect.Map {
+			input = "1=1"
+		}
+		converted, err := convertString(reflect.TypeOf(expected), input)
+		assert.NoError(err)
+		assert.Equal(expected, converted)
+	}
+}
+
+func TestEnvPointers(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	os.Setenv("ENV_POINTERS_PORT", "0")
+	os.Setenv("ENV_POINTERS_DEBUG", "false")
+	result := struct {
+		Port    *int
+		Debug   *bool
+		Timeout *time.Duration
+		Nested  *struct {
+			Name *string
+		}
+	}{}
+	err := Env(WithPrefix("ENV_POINTERS"), ComputeEnvKey(UpperSnakeCase)).Process(&result)
+	require.NoError(err)
+	require.NotNil(result.Port)
+	assert.Equal(0, *result.Port)
+	require.NotNil(result.Debug)
+	assert.False(*result.Debug)
+	assert.Nil(result.Timeout)
+	assert.Nil(result.Nested.Name)
+}
+
+func TestSplitEscaped(t *testing.T) {
+	assert := assert.New(t)
+	valid := []struct {
+		input, delim, kvDelim string
+		n                     int
+		unquote               bool
+		expected              []string
+	}{
+		{"a,b,c", ",", "", -1, true, []string{"a", "b", "c"}},
+		{"", ",", "", -1, true, []string{""}},
+		{`a\,b,c`, ",", "", -1, true, []string{"a,b", "c"}},
+		{`"a,b",c\"d`, ",", "", -1, true, []string{"a,b", `c"d`}},
+		{`pa"ss,b`, ",", "", -1, true, []string{`pa"ss`, "b"}},
+		{`"a,b"c,d`, ",", "", -1, true, []string{"a,bc", "d"}},
+		{`C:\dir,\\`, ",", "", -1, true, []string{`C:\dir`, `\`}},
+		{`"a,b",c\,d`, ",", "", -1, false, []string{`"a,b"`, `c\,d`}},
+		{"a;;b;;c", ";;", "", 2, true, []string{"a", "b;;c"}},
+		{`k="v,1",x\=y="z"`, ",", "=", -1, false, []string{`k="v,1"`, `x\=y="z"`}},
+		{`k=va"l,x=y`, ",", "=", -1, false, []string{`k=va"l`, "x=y"}},
+	}
+	for _, v := range valid {
+		parts, err := splitEscaped(v.input, v.delim, v.kvDelim, v.n, v.unquote)
+		assert.NoError(err, v.input)
+		assert.Equal(v.expected, parts, v.input)
+	}
+
+	_, err := splitEscaped(`a,"b,c`, ",", "", -1, true)
+	assert.EqualError(err, "unterminated quote in element 2")
+	_, err = splitEscaped(`k="v,x=y`, ",", "=", -1, false)
+	assert.Error(err)
+}
+
+func TestConvertDelimiters(t *testing.T) {
+	assert := assert.New(t)
+	converted, err := convertString(reflect.TypeOf(map[string]string{}), `secret=c2VjcmV0==,"a,b"=c\=d`)
+	assert.NoError(err)
+	assert.Equal(map[string]string{"secret": "c2VjcmV0==", "a,b": "c=d"}, converted)
+	converted, err = convertString(reflect.TypeOf(map[string]string{}), `k=va"l,x=y,q="v,1"`)
+	assert.NoError(err)
+	assert.Equal(map[string]string{"k": `va"l`, "x": "y", "q": "v,1"}, converted)
+	converted, err = convertString(reflect.TypeOf(map[string]string{}), `k=a\,b,x\,y=z`)
+	assert.NoError(err)
+	assert.Equal(map[string]string{"k": "a,b", "x,y": "z"}, converted)
+	converted, err = convertString(reflect.TypeOf([]string{}), `pa"ss,b`)
+	assert.NoError(err)
+	assert.Equal([]string{`pa"ss`, "b"}, converted)
+	_, err = convertString(reflect.TypeOf([]string{}), `"pass,b`)
+	assert.Error(err)
+
+	o := defaultEnvOptions()
+	WithSliceDelimiter(";").apply(&o)
+	WithMapDelimiters(";", ":").apply(&o)
+	c := o.converter()
+	converted, err = c.convert(reflect.TypeOf([]string{}), "a,b;c")
+	assert.NoError(err)
+	assert.Equal([]string{"a,b", "c"}, converted)
+	converted, err = c.convert(reflect.TypeOf(map[string]int{}), "a:1;b:2")
+	assert.NoError(err)
+	assert.Equal(map[string]int{"a": 1, "b": 2}, converted)
+}
+
+func TestEnvDelimiters(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	os.Setenv("ENV_DELIMITERS_DSNS", "postgres://db1?a=1,b=2;postgres://db2")
+	os.Setenv("ENV_DELIMITERS_LABELS", "app:web|tier:front")
+	os.Setenv("ENV_DELIMITERS_PORTS", "80 443")
+	result := struct {
+		DSNs   []string          `env:"DSNS,sep=;"`
+		Labels map[string]string `env:"LABELS,sep=|,kvsep=:"`
+		Ports  []int             `env:"PORTS"`
+	}{}
+	err := Env(WithPrefix("ENV_DELIMITERS"), WithSliceDelimiter(" ")).Process(&result)
+	require.NoError(err)
+	assert.Equal([]string{"postgres://db1?a=1,b=2", "postgres://db2"}, result.DSNs)
+	assert.Equal(map[string]string{"app": "web", "tier": "front"}, result.Labels)
+	assert.Equal([]int{80, 443}, result.Ports)
+
+	invalid := struct {
+		Hosts []string `env:"DSNS,sep="`
+	}{}
+	err = Env(WithPrefix("ENV_DELIMITERS")).Process(&invalid)
+	assert.Error(err)
+}
+
+func TestEnvJSON(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	type route struct {
+		Path    string `json:"path"`
+		Backend string `json:"backend"`
+	}
+	os.Setenv("ENV_JSON_ROUTES", `[{"path":"/api","backend":"api:8080"}]`)
+	os.Setenv("ENV_JSON_GROUPS", `{"admins":["alice","bob"]}`)
+	os.Setenv("ENV_JSON_DEFAULT", `{"path":"/","backend":"web:80"}`)
+	os.Setenv("ENV_JSON_HOSTS", "a,b")
+	result := struct {
+		Routes  []route             `env:"ROUTES"`
+		Groups  map[string][]string `env:"GROUPS"`
+		Default *route              `env:"DEFAULT,json"`
+		Hosts   []string            `env:"HOSTS"`
+	}{}
+	err := Env(WithPrefix("ENV_JSON")).Process(&result)
+	assert.Error(err) // Routes are not supported without JSON
+	err = Env(WithPrefix("ENV_JSON"), DecodeJSON(false)).Process(&result)
+	assert.Error(err)
+
+	err = Env(WithPrefix("ENV_JSON"), DecodeJSON()).Process(&result)
+	assert.Error(err) // Hosts are not valid JSON
+
+	os.Setenv("ENV_JSON_HOSTS", `["a","b"]`)
+	err = Env(WithPrefix("ENV_JSON"), DecodeJSON()).Process(&result)
+	require.NoError(err)
+	assert.Equal([]route{{Path: "/api", Backend: "api:8080"}}, result.Routes)
+	assert.Equal(map[string][]string{"admins": {"alice", "bob"}}, result.Groups)
+	require.NotNil(result.Default)
+	assert.Equal(route{Path: "/", Backend: "web:80"}, *result.Default)
+	assert.Equal([]string{"a", "b"}, result.Hosts)
+}
+
+func TestEnvIndexed(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	type backend struct {
+		Host string
+		Port int
+		TLS  struct {
+			Enabled bool
+		}
+	}
+	os.Setenv("ENV_INDEXED_BACKENDS_0_HOST", "a.example.com")
+	os.Setenv("ENV_INDEXED_BACKENDS_0_TLS_ENABLED", "true")
+	os.Setenv("ENV_INDEXED_BACKENDS_1_HOST", "b.example.com")
+	os.Setenv("ENV_INDEXED_BACKENDS_2_HOST", "c.example.com")
+	os.Setenv("ENV_INDEXED_BACKENDS_2_PORT", "8080")
+	os.Setenv("ENV_INDEXED_BACKENDS_X_HOST", "ignored")
+	result := struct {
+		Backends []backend
+		Pointers []*backend `env:"BACKENDS"`
+		Empty    []backend
+	}{}
+	err := Env(WithPrefix("ENV_INDEXED"), ComputeEnvKey(UpperSnakeCase)).Process(&result)
+	require.NoError(err)
+	require.Len(result.Backends, 3)
+	assert.Equal("a.example.com", result.Backends[0].Host)
+	assert.True(result.Backends[0].TLS.Enabled)
+	assert.Equal(backend{Host: "b.example.com"}, result.Backends[1])
+	assert.Equal("c.example.com", result.Backends[2].Host)
+	assert.Equal(8080, result.Backends[2].Port)
+	require.Len(result.Pointers, 3)
+	assert.Equal(result.Backends[2], *result.Pointers[2])
+	assert.Nil(result.Empty)
+
+	os.Setenv("ENV_INDEXED_BACKENDS_1_PORT", "http")
+	err = Env(WithPrefix("ENV_INDEXED"), ComputeEnvKey(UpperSnakeCase)).Process(&result)
+	var fieldErr *FieldError
+	require.ErrorAs(err, &fieldErr)
+	assert.Equal("Backends.1.Port", fieldErr.Path)
+	assert.Equal("ENV_INDEXED_BACKENDS_1_PORT", fieldErr.Source.Name)
+	os.Unsetenv("ENV_INDEXED_BACKENDS_1_PORT")
+
+	// Gaps in the indices are rejected rather than resulting in empty elements
+	os.Setenv("ENV_INDEXED_BACKENDS_60000_HOST", "z.example.com")
+	err = Env(WithPrefix("ENV_INDEXED"), ComputeEnvKey(UpperSnakeCase)).Process(&result)
+	require.ErrorAs(err, &fieldErr)
+	assert.Equal("Backends.3", fieldErr.Path)
+	assert.Equal("ENV_INDEXED_BACKENDS_3", fieldErr.Source.Name)
+	os.Unsetenv("ENV_INDEXED_BACKENDS_60000_HOST")
+}
+
+func TestEnvCollected(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	type database struct {
+		Host     string
+		Port     int
+		Replicas []struct {
+			Host string
+		}
+	}
+	os.Setenv("ENV_COLLECTED_DATABASES_PRIMARY_HOST", "db1")
+	os.Setenv("ENV_COLLECTED_DATABASES_PRIMARY_PORT", "5432")
+	os.Setenv("ENV_COLLECTED_DATABASES_PRIMARY_REPLICAS_0_HOST", "db1r")
+	os.Setenv("ENV_COLLECTED_DATABASES_EU_WEST_HOST", "db2")
+	os.Setenv("ENV_COLLECTED_DATABASES_UNKNOWN", "ignored")
+	os.Setenv("ENV_COLLECTED_LABELS_TIER", "web")
+	os.Setenv("ENV_COLLECTED_LABELS_TEAM", "platform")
+	os.Setenv("ENV_COLLECTED_PORTS_HTTP", "80")
+	result := struct {
+		Databases map[string]*database
+		Labels    map[string]string `env:"LABELS,collect"`
+		Ports     map[string]int    `env:"PORTS,collect"`
+		Empty     map[string]database
+	}{}
+	err := Env(WithPrefix("ENV_COLLECTED"), ComputeEnvKey(UpperSnakeCase)).Process(&result)
+	require.NoError(err)
+	require.Len(result.Databases, 2)
+	require.NotNil(result.Databases["primary"])
+	assert.Equal("db1", result.Databases["primary"].Host)
+	assert.Equal(5432, result.Databases["primary"].Port)
+	require.Len(result.Databases["primary"].Replicas, 1)
+	assert.Equal("db1r", result.Databases["primary"].Replicas[0].Host)
+	require.NotNil(result.Databases["eu_west"])
+	assert.Equal("db2", result.Databases["eu_west"].Host)
+	assert.Equal(map[string]string{"tier": "web", "team": "platform"}, result.Labels)
+	assert.Equal(map[string]int{"http": 80}, result.Ports)
+	assert.Nil(result.Empty)
+
+	os.Setenv("ENV_COLLECTED_PORTS_HTTPS", "tls")
+	err = Env(WithPrefix("ENV_COLLECTED"), ComputeEnvKey(UpperSnakeCase)).Process(&result)
+	var fieldErr *FieldError
+	require.ErrorAs(err, &fieldErr)
+	assert.Equal("Ports.https", fieldErr.Path)
+	os.Unsetenv("ENV_COLLECTED_PORTS_HTTPS")
+
+	invalid := struct {
+		Labels []string `env:"LABELS,collect"`
+	}{}
+	err = Env(WithPrefix("ENV_COLLECTED")).Process(&invalid)
+	assert.Error(err)
+}
+
+func TestEnvFileSuffix(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	dir := t.TempDir()
+	secret := filepath.Join(dir, "secret")
+	err := os.WriteFile(secret, []byte("s3cr3t\n"), 0600)
+	require.NoError(err)
+	port := filepath.Join(dir, "port")
+	err = os.WriteFile(port, []byte("8080\r\n"), 0600)
+	require.NoError(err)
+
+	os.Setenv("ENV_FILE_SUFFIX_PASSWORD_FILE", secret)
+	os.Setenv("ENV_FILE_SUFFIX_PORT_FILE", port)
+	os.Setenv("ENV_FILE_SUFFIX_USER", "admin")
+	result := struct {
+		Password string `env:"PASSWORD,file"`
+		Port     int    `env:"PORT"`
+		User     string `env:"USER"`
+	}{}
+	err = Env(WithPrefix("ENV_FILE_SUFFIX")).Process(&result)
+	require.NoError(err)
+	assert.Equal("s3cr3t", result.Password)
+	assert.Equal(0, result.Port)
+
+	err = Env(WithPrefix("ENV_FILE_SUFFIX"), SupportFileSuffix()).Process(&result)
+	require.NoError(err)
+	assert.Equal(8080, result.Port)
+	assert.Equal("admin", result.User)
+
+	err = Env(WithPrefix("ENV_FILE_SUFFIX"), KeepTrailingNewline()).Process(&result)
+	require.NoError(err)
+	assert.Equal("s3cr3t\n", result.Password)
+
+	os.Setenv("ENV_FILE_SUFFIX_USER_FILE", secret)
+	err = Env(WithPrefix("ENV_FILE_SUFFIX"), SupportFileSuffix()).Process(&result)
+	assert.Error(err) // Either USER or USER_FILE is allowed
+	os.Unsetenv("ENV_FILE_SUFFIX_USER_FILE")
+
+	os.Setenv("ENV_FILE_SUFFIX_PORT_FILE", secret)
+	err = Env(WithPrefix("ENV_FILE_SUFFIX"), SupportFileSuffix()).Process(&result)
+	var fieldErr *FieldError
+	require.ErrorAs(err, &fieldErr)
+	assert.Equal("ENV_FILE_SUFFIX_PORT_FILE", fieldErr.Source.Name)
+	assert.Empty(fieldErr.RawValue)
+	assert.NotContains(err.Error(), "s3cr3t")
+
+	// Conversion errors must not expose the contents for any type
+	os.Setenv("ENV_FILE_SUFFIX_TIMEOUT_FILE", secret)
+	os.Setenv("ENV_FILE_SUFFIX_LABELS_FILE", secret)
+	defer os.Unsetenv("ENV_FILE_SUFFIX_TIMEOUT_FILE")
+	defer os.Unsetenv("ENV_FILE_SUFFIX_LABELS_FILE")
+	secrets := struct {
+		Port    int               `env:"PORT"`
+		Timeout time.Duration     `env:"TIMEOUT"`
+		Labels  map[string]string `env:"LABELS"`
+	}{}
+	_, err = LoadWithOptions(&secrets, []Loader{Env(WithPrefix("ENV_FILE_SUFFIX"), SupportFileSuffix())}, CollectErrors())
+	var multiErr *MultiError
+	require.ErrorAs(err, &multiErr)
+	assert.Len(multiErr.Errors, 3)
+	assert.NotContains(err.Error(), "s3cr3t")
+
+	os.Setenv("ENV_FILE_SUFFIX_PORT_FILE", filepath.Join(dir, "missing"))
+	err = Env(WithPrefix("ENV_FILE_SUFFIX"), SupportFileSuffix()).Process(&result)
+	assert.ErrorIs(err, os.ErrNotExist)
+}
+
+func TestEnvSources(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+	require := require.New(t)
+	type config struct {
+		Port     int
+		Backends []struct {
+			Host string
+		}
+	}
+	env := map[string]string{
+		"MYAPP_PORT":            "8080",
+		"MYAPP_BACKENDS_0_HOST": "a.example.com",
+	}
+
+	result := config{}
+	err := Env(WithPrefix("MYAPP"), ComputeEnvKey(UpperSnakeCase), FromMap(env)).Process(&result)
+	require.NoError(err)
+	assert.Equal(8080, result.Port)
+	require.Len(result.Backends, 1)
+	assert.Equal("a.example.com", result.Backends[0].Host)
+
+	result = config{}
+	environ := []string{"MYAPP_PORT=80", "MYAPP_PORT=443", "MYAPP_BACKENDS_0_HOST=b=c"}
+	err = Env(WithPrefix("MYAPP"), ComputeEnvKey(UpperSnakeCase), FromEnviron(environ)).Process(&result)
+	require.NoError(err)
+	assert.Equal(443, result.Port)
+	require.Len(result.Backends, 1)
+	assert.Equal("b=c", result.Backends[0].Host)
+
+	result = config{}
+	lookup := func(key string) (string, bool) {
+		v, ok := env[key]
+		return v, ok
+	}
+	err = Env(WithPrefix("MYAPP"), ComputeEnvKey(UpperSnakeCase), WithLookup(lookup)).Process(&result)
+	require.NoError(err)
+	assert.Equal(8080, result.Port)
+	assert.Nil(result.Backends)
+}
+
+func TestEnvStrictPrefix(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	type config struct {
+		DatabaseURL string
+		Password    string `env:"PASSWORD,file"`
+		Backends    []struct {
+			Host string
+		}
+		Labels map[string]string `env:"LABELS,collect"`
+	}
+	env := map[string]string{
+		"MYAPP_DATABASE_URL":    "postgres://db",
+		"MYAPP_PASSWORD_FILE":   "/dev/null",
+		"MYAPP_BACKENDS_0_HOST": "a.example.com",
+		"MYAPP_LABELS_TIER":     "web",
+		"OTHER_VAR":             "ignored",
+	}
+	result := config{}
+	err := Env(WithPrefix("MYAPP"), ComputeEnvKey(UpperSnakeCase), FromMap(env), StrictPrefix()).Process(&result)
+	require.NoError(err)
+
+	env["MYAPP_DATABSE_URL"] = "typo"
+	env["MYAPP_BACKENDS_0_HOTS"] = "typo"
+	env["MYAPP_COMPLETELY_DIFFERENT"] = "unknown"
+	err = Env(WithPrefix("MYAPP"), ComputeEnvKey(UpperSnakeCase), FromMap(env), StrictPrefix()).Process(&result)
+	var unknownErr *UnknownEnvVarsError
+	require.ErrorAs(err, &unknownErr)
+	assert.Equal([]UnknownEnvVar{
+		{Name: "MYAPP_BACKENDS_0_HOTS", Suggestion: "MYAPP_BACKENDS_0_HOST"},
+		{Name: "MYAPP_COMPLETELY_DIFFERENT"},
+		{Name: "MYAPP_DATABSE_URL", Suggestion: "MYAPP_DATABASE_URL"},
+	}, unknownErr.Vars)
+	assert.Contains(err.Error(), "'MYAPP_DATABSE_URL' (did you mean 'MYAPP_DATABASE_URL'?)")
+
+	result = config{}
+	_, err = LoadWithOptions(&result, []Loader{
+		Env(WithPrefix("MYAPP"), ComputeEnvKey(UpperSnakeCase), FromMap(env), StrictPrefix()),
+	}, CollectErrors())
+	require.ErrorAs(err, &unknownErr)
+	assert.Equal("postgres://db", result.DatabaseURL)
+
+	// Typos can not be detected without enumerating the variables
+	lookup := func(key string) (string, bool) {
+		v, ok := env[key]
+		return v, ok
+	}
+	err = Env(WithPrefix("MYAPP"), ComputeEnvKey(UpperSnakeCase), WithLookup(lookup), StrictPrefix()).Process(&result)
+	assert.Error(err)
+	err = Env(WithPrefix("MYAPP"), ComputeEnvKey(UpperSnakeCase), WithLookup(lookup), StrictPrefix(false)).Process(&result)
+	assert.NoError(err)
+}
+
+func TestLevenshtein(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal(0, levenshtein("", ""))
+	assert.Equal(3, levenshtein("abc", ""))
+	assert.Equal(1, levenshtein("DATABSE", "DATABASE"))
+	assert.Equal(2, levenshtein("HOTS", "HOST"))
+	assert.Equal(3, levenshtein("kitten", "sitting"))
+}
@@ -0,0 +1,189 @@
+package copre
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// MissingField describes a required field, that was not populated.
+type MissingField struct {
+	// Path of the field as used by Report.
+	Path string
+	// Candidates are the sources, that could have populated the field, e.g.
+	// the environment variable or flag name.
+	Candidates []Source
+}
+
+// MissingFieldsError is returned by Load if required fields were not populated.
+type MissingFieldsError struct {
+	Fields []MissingField
+}
+
+func (e *MissingFieldsError) Error() string {
+	fields := make([]string, 0, len(e.Fields))
+	for _, f := range e.Fields {
+		field := fmt.Sprintf("'.%s'", f.Path)
+		if len(f.Candidates) > 0 {
+			candidates := make([]string, 0, len(f.Candidates))
+			for _, c := range f.Candidates {
+				candidates = append(candidates, c.String())
+			}
+			field += fmt.Sprintf(" (%s)", strings.Join(candidates, ", "))
+		}
+		fields = append(fields, field)
+	}
+	return fmt.Sprintf("missing required fields: %s", strings.Join(fields, ", "))
+}
+
+// UnknownEnvVar describes an environment variable, that does not map to any
+// field.
+type UnknownEnvVar struct {
+	// Name of the environment variable.
+	Name string
+	// Suggestion is the most similar variable mapping to a field, if any.
+	Suggestion string
+}
+
+// UnknownEnvVarsError is returned by Env with StrictPrefix, if environment
+// variables carrying the prefix do not map to any field.
+type UnknownEnvVarsError struct {
+	Vars []UnknownEnvVar
+}
+
+func (e *UnknownEnvVarsError) Error() string {
+	vars := make([]string, 0, len(e.Vars))
+	for _, v := range e.Vars {
+		name := fmt.Sprintf("'%s'", v.Name)
+		if v.Suggestion != "" {
+			name += fmt.Sprintf(" (did you mean '%s'?)", v.Suggestion)
+		}
+		vars = append(vars, name)
+	}
+	return fmt.Sprintf("unknown environment variables: %s", strings.Join(vars, ", "))
+}
+
+var (
+	// ErrUnsupportedType is wrapped by errors returned if a string can not
+	// be converted to the type of a field, because the type is not supported.
+	ErrUnsupportedType = errors.New("unsupported type")
+	// ErrTypeMismatch is wrapped by errors returned if the type of a value
+	// retrieved by a loader does not match the type of the field.
+	ErrTypeMismatch = errors.New("type mismatch")
+	// ErrUnsupportedFormat is wrapped by errors returned if no UnmarshalFunc
+	// is registered for the extension of a file, see RegisterFormat.
+	ErrUnsupportedFormat = errors.New("unsupported format")
+)
+
+// FieldError describes the failure to populate a specific field, e.g. because
+// a value could not be converted or its type does not match.
+type FieldError struct {
+	// Path of the field as used by Report.
+	Path string
+	// Source the value was retrieved from, if known. The name of the source
+	// is the key used to retrieve the value, e.g. the environment variable.
+	Source Source
+	// RawValue is the value as retrieved from the source, if available.
+	RawValue string
+	// TargetType is the type the value was supposed to be converted to.
+	TargetType reflect.Type
+	// Err is the underlying error.
+	Err error
+}
+
+func (e *FieldError) Error() string {
+	msg := fmt.Sprintf("failed to set value at path '.%s'", e.Path)
+	if e.Source.Kind != "" {
+		msg += fmt.Sprintf(" from %s", e.Source)
+	}
+	if e.RawValue != "" {
+		msg += fmt.Sprintf(" with value '%s'", e.RawValue)
+	}
+	return fmt.Sprintf("%s: %s", msg, e.Err)
+}
+
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+// FileError describes the failure to read or unmarshal a specific file.
+type FileError struct {
+	// Path of the file.
+	Path string
+	// Err is the underlying error.
+	Err error
+}
+
+func (e *FileError) Error() string {
+	return fmt.Sprintf("failed to load '%s': %s", e.Path, e.Err)
+}
+
+func (e *FileError) Unwrap() error {
+	return e.Err
+}
+
+// MultiError aggregates all errors encountered while loading, if errors are
+// collected rather than returned immediately, see CollectErrors.
+// Errors related to a specific field are of type *FieldError.
+type MultiError struct {
+	Errors []error
+}
+
+func (e *MultiError) Error() string {
+	msgs := make([]string, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		msgs = append(msgs, "* "+err.Error())
+	}
+	return fmt.Sprintf("%d errors occurred:\n%s", len(e.Errors), strings.Join(msgs, "\n"))
+}
+
+// Unwrap returns the aggregated errors.
+func (e *MultiError) Unwrap() []error {
+	return e.Errors
+}
+
+// Is reports whether any of the aggregated errors matches target. It allows
+// errors.Is to inspect the aggregated errors prior to Go 1.20, which does not
+// support Unwrap returning multiple errors.
+func (e *MultiError) Is(target error) bool {
+	for _, err := range e.Errors {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// As finds the first aggregated error matching target and sets target to it.
+// It allows errors.As to inspect the aggregated errors prior to Go 1.20.
+func (e *MultiError) As(target interface{}) bool {
+	for _, err := range e.Errors {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// FieldErrors returns all aggregated errors of type *FieldError.
+func (e *MultiError) FieldErrors() []*FieldError {
+	fieldErrs := []*FieldError{}
+	for _, err := range e.Errors {
+		var fieldErr *FieldError
+		if errors.As(err, &fieldErr) {
+			fieldErrs = append(fieldErrs, fieldErr)
+		}
+	}
+	return fieldErrs
+}
+
+// errorList returns the errors aggregated by err, if it is a *MultiError, or
+// err itself otherwise.
+func errorList(err error) []error {
+	var multiErr *MultiError
+	if errors.As(err, &multiErr) {
+		return multiErr.Errors
+	}
+	return []error{err}
+}
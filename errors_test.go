@@ -0,0 +1,95 @@
+package copre
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFieldError(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	os.Setenv("FIELD_ERROR_PORT", "abc")
+	os.Setenv("FIELD_ERROR_OTHER", "1")
+	result := struct {
+		Port  int      `env:"PORT"`
+		Other chan int `env:"OTHER"`
+	}{}
+
+	err := Env(WithPrefix("FIELD_ERROR")).Process(&result)
+	var fieldErr *FieldError
+	require.True(errors.As(err, &fieldErr))
+	assert.Equal("Port", fieldErr.Path)
+	assert.Equal(Source{Kind: SourceEnv, Name: "FIELD_ERROR_PORT"}, fieldErr.Source)
+	assert.Equal("abc", fieldErr.RawValue)
+	assert.Equal(reflect.TypeOf(0), fieldErr.TargetType)
+	assert.True(errors.Is(err, strconv.ErrSyntax))
+	assert.Equal(`failed to set value at path '.Port' from env FIELD_ERROR_PORT with value 'abc': strconv.ParseInt: parsing "abc": invalid syntax`, err.Error())
+
+	os.Setenv("FIELD_ERROR_PORT", "1")
+	err = Env(WithPrefix("FIELD_ERROR")).Process(&result)
+	assert.True(errors.Is(err, ErrUnsupportedType))
+
+	err = StructWalk(&result, func(path []string, field reflect.StructField) (interface{}, error) {
+		return "a", nil
+	})
+	assert.True(errors.Is(err, ErrTypeMismatch))
+}
+
+func TestFileError(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	tf, err := ioutil.TempFile("", "test")
+	require.NoError(err)
+	defer os.Remove(tf.Name())
+	_, err = tf.WriteString(`{ "a": `)
+	require.NoError(err)
+
+	result := TestConfigFileOptions{}
+	err = File(tf.Name(), json.Unmarshal).Process(&result)
+	var fileErr *FileError
+	require.True(errors.As(err, &fileErr))
+	assert.Equal(tf.Name(), fileErr.Path)
+	var syntaxErr *json.SyntaxError
+	assert.True(errors.As(err, &syntaxErr))
+}
+
+func TestMultiError(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	result := struct {
+		A int
+		B chan int
+	}{}
+	env := map[string]string{"A": "x", "B": "y"}
+	_, err := LoadWithOptions(&result, []Loader{
+		Env(ComputeEnvKey(UpperSnakeCase), FromMap(env)),
+	}, CollectErrors())
+	var multiErr *MultiError
+	require.True(errors.As(err, &multiErr))
+	require.Len(multiErr.Errors, 2)
+
+	// Is and As are used by errors.Is and errors.As prior to Go 1.20, which
+	// does not follow Unwrap returning multiple errors, so test them directly
+	assert.True(multiErr.Is(strconv.ErrSyntax))
+	assert.True(multiErr.Is(ErrUnsupportedType))
+	assert.False(multiErr.Is(ErrTypeMismatch))
+	var fieldErr *FieldError
+	require.True(multiErr.As(&fieldErr))
+	assert.Equal("A", fieldErr.Path)
+	var fileErr *FileError
+	assert.False(multiErr.As(&fileErr))
+
+	assert.True(errors.Is(err, ErrUnsupportedType))
+	assert.True(errors.As(err, &fieldErr))
+}
@@ -0,0 +1,138 @@
+package copre
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// expandEnvStrict replaces references to variables in s using lookup, see
+// ExpandEnvStrict for the supported syntax. All failed references are
+// reported by the returned error.
+func expandEnvStrict(s string, lookup func(string) (string, bool)) (string, error) {
+	var (
+		b    strings.Builder
+		errs []string
+	)
+	for i := 0; i < len(s); i++ {
+		if s[i] != '$' || i+1 >= len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+		switch next := s[i+1]; {
+		case next == '$':
+			b.WriteByte('$')
+			i++
+		case next == '{':
+			end := matchingBrace(s, i+1)
+			if end < 0 {
+				// Only the name is reported, as the remainder might contain
+				// secrets
+				n := i + 2
+				for n < len(s) && isNameChar(s[n]) {
+					n++
+				}
+				line := strings.Count(s[:i], "\n") + 1
+				errs = append(errs, fmt.Sprintf("line %d: unterminated reference '%s'", line, s[i:n]))
+				i = len(s)
+				continue
+			}
+			value, err := expandExpression(s[i+2:end], lookup)
+			if err != nil {
+				errs = append(errs, err.Error())
+			}
+			b.WriteString(value)
+			i = end
+		case isNameStart(next):
+			end := i + 1
+			for end < len(s) && isNameChar(s[end]) {
+				end++
+			}
+			name := s[i+1 : end]
+			value, ok := lookup(name)
+			if !ok {
+				errs = append(errs, fmt.Sprintf("variable '%s' is not defined", name))
+			}
+			b.WriteString(value)
+			i = end - 1
+		default:
+			b.WriteByte('$')
+		}
+	}
+	if len(errs) > 0 {
+		return "", errors.New(strings.Join(errs, "; "))
+	}
+	return b.String(), nil
+}
+
+// expandExpression evaluates the contents of a reference enclosed in braces,
+// e.g. "VAR:-default".
+func expandExpression(expr string, lookup func(string) (string, bool)) (string, error) {
+	n := 0
+	for n < len(expr) && isNameChar(expr[n]) {
+		n++
+	}
+	name, op := expr[:n], expr[n:]
+	if name == "" || !isNameStart(name[0]) {
+		return "", fmt.Errorf("invalid reference '${%s}'", expr)
+	}
+	value, ok := lookup(name)
+	// The colon variants also apply to empty values
+	unset, notSet := !ok, "not defined"
+	if strings.HasPrefix(op, ":") {
+		notSet = "not defined or empty"
+		unset = !ok || value == ""
+		op = op[1:]
+		if op == "" || (op[0] != '-' && op[0] != '?') {
+			return "", fmt.Errorf("invalid reference '${%s}'", expr)
+		}
+	}
+	switch {
+	case op == "":
+		if !ok {
+			return "", fmt.Errorf("variable '%s' is not defined", name)
+		}
+		return value, nil
+	case op[0] == '-':
+		if unset {
+			return expandEnvStrict(op[1:], lookup)
+		}
+		return value, nil
+	case op[0] == '?':
+		if unset {
+			msg := op[1:]
+			if msg == "" {
+				msg = notSet
+			}
+			return "", fmt.Errorf("variable '%s': %s", name, msg)
+		}
+		return value, nil
+	}
+	return "", fmt.Errorf("invalid reference '${%s}'", expr)
+}
+
+// matchingBrace returns the index of the brace closing the one at index open
+// or -1 if there is none.
+func matchingBrace(s string, open int) int {
+	depth := 0
+	for i := open; i < len(s); i++ {
+		switch s[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+func isNameStart(c byte) bool {
+	return c == '_' || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z')
+}
+
+func isNameChar(c byte) bool {
+	return isNameStart(c) || ('0' <= c && c <= '9')
+}
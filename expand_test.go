@@ -0,0 +1,54 @@
+package copre
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpandEnvStrict(t *testing.T) {
+	assert := assert.New(t)
+	env := map[string]string{
+		"USER":  "admin",
+		"EMPTY": "",
+	}
+	lookup := func(key string) (string, bool) {
+		v, ok := env[key]
+		return v, ok
+	}
+	valid := map[string]string{
+		"plain":                          "plain",
+		"$USER ${USER}":                  "admin admin",
+		"pa$$word$":                      "pa$word$",
+		"$1 ${EMPTY}":                    "$1 ",
+		"${UNSET:-default}":              "default",
+		"${EMPTY:-default}":              "default",
+		"${EMPTY-default}":               "",
+		"${USER:-default}":               "admin",
+		"${UNSET:-${USER}}@${UNSET-$$x}": "admin@$x",
+		"${USER:?required}":              "admin",
+		"${EMPTY?required}":              "",
+	}
+	for input, expected := range valid {
+		expanded, err := expandEnvStrict(input, lookup)
+		assert.NoError(err, input)
+		assert.Equal(expected, expanded, input)
+	}
+
+	invalid := map[string]string{
+		"$UNSET":                        "variable 'UNSET' is not defined",
+		"${UNSET}":                      "variable 'UNSET' is not defined",
+		"${UNSET:?is required}":         "variable 'UNSET': is required",
+		"${EMPTY:?}":                    "variable 'EMPTY': not defined or empty",
+		"${UNSET?}":                     "variable 'UNSET': not defined",
+		"${USER":                        "line 1: unterminated reference '${USER'",
+		"a: 1\nb: ${USER:-x\nc: secret": "line 2: unterminated reference '${USER'",
+		"${1}":                          "invalid reference '${1}'",
+		"${USER:x}":                     "invalid reference '${USER:x}'",
+		"$A and $B":                     "variable 'A' is not defined; variable 'B' is not defined",
+	}
+	for input, expected := range invalid {
+		_, err := expandEnvStrict(input, lookup)
+		assert.EqualError(err, expected, input)
+	}
+}
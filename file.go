@@ -1,16 +1,44 @@
 package copre
 
 import (
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"io/ioutil"
 	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
 )
 
 type fileOptions struct {
-	ignoreNotFound bool
-	expandEnv      bool
-	mergeFiles     bool
-	filePaths      []string
+	ignoreNotFound    bool
+	expandEnv         bool
+	expandEnvStrict   bool
+	mergeFiles        bool
+	reverseMergeOrder bool
+	filePaths         []searchPath
+	fsys              fs.FS
+}
+
+// searchPath is the path of a configuration file or, if pattern is set, a
+// directory, which is expanded to the files matching pattern when processed.
+type searchPath struct {
+	path    string
+	pattern string
+	// If expand is set, it returns the paths when processed instead.
+	expand func() []string
+}
+
+func toSearchPaths(paths []string) []searchPath {
+	fps := make([]searchPath, 0, len(paths))
+	for _, p := range paths {
+		fps = append(fps, searchPath{path: p})
+	}
+	return fps
 }
 
 // FileOption configures how given configuration files are used to populate a given structure.
@@ -30,10 +58,83 @@ func (c fileOptionAdapter) apply(o *fileOptions) {
 // See File for details on how configuration files are located.
 func AppendFilePaths(paths ...string) FileOption {
 	return fileOptionAdapter(func(o *fileOptions) {
-		o.filePaths = append(o.filePaths, paths...)
+		o.filePaths = append(o.filePaths, toSearchPaths(paths)...)
+	})
+}
+
+// Directory appends all files in dir matching pattern to the list of paths
+// used to locate configuration files, see filepath.Match for the syntax of
+// pattern. The files are expanded when File is processed and appended in
+// lexical order, so combined with MergeFiles files are layered, e.g. for the
+// drop-in directory "/etc/myapp/conf.d":
+//  File("/etc/myapp/config.yaml", yaml.Unmarshal,
+//    Directory("/etc/myapp/conf.d", "*.yaml"), // 10-defaults.yaml, 99-local.yaml, ...
+//    MergeFiles(),
+//  )
+func Directory(dir, pattern string) FileOption {
+	return fileOptionAdapter(func(o *fileOptions) {
+		o.filePaths = append(o.filePaths, searchPath{path: dir, pattern: pattern})
+	})
+}
+
+// StandardPaths appends the conventional locations of the configuration file
+// fileName of the application appName to the list of paths used to locate
+// configuration files, in order of precedence:
+//  - the current working directory, e.g. "./config.yaml"
+//  - "$XDG_CONFIG_HOME/myapp/config.yaml", if XDG_CONFIG_HOME is set
+//  - the user configuration directory of the platform, see os.UserConfigDir
+//  - "~/.config/myapp/config.yaml"
+//  - every entry of $XDG_CONFIG_DIRS, by default "/etc/xdg/myapp/config.yaml"
+//  - "/etc/myapp/config.yaml"
+// Duplicate locations are omitted. The locations are determined when File is
+// processed, so changes of the environment prior to that are respected.
+// As File loads the first file found, this results in the expected
+// precedence. If MergeFiles is specified, ReverseMergeOrder should be as
+// well, so files with higher precedence are merged last. For example:
+//  File("", yaml.Unmarshal, StandardPaths("myapp", "config.yaml"), MergeFiles(), ReverseMergeOrder())
+func StandardPaths(appName, fileName string) FileOption {
+	return fileOptionAdapter(func(o *fileOptions) {
+		o.filePaths = append(o.filePaths, searchPath{expand: func() []string {
+			return standardPaths(appName, fileName)
+		}})
 	})
 }
 
+// standardPaths returns the locations used by StandardPaths.
+func standardPaths(appName, fileName string) []string {
+	paths := []string{fileName}
+	if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
+		paths = append(paths, filepath.Join(dir, appName, fileName))
+	}
+	if dir, err := os.UserConfigDir(); err == nil {
+		paths = append(paths, filepath.Join(dir, appName, fileName))
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		paths = append(paths, filepath.Join(home, ".config", appName, fileName))
+	}
+	configDirs := os.Getenv("XDG_CONFIG_DIRS")
+	if configDirs == "" {
+		configDirs = "/etc/xdg"
+	}
+	for _, dir := range filepath.SplitList(configDirs) {
+		if dir != "" {
+			paths = append(paths, filepath.Join(dir, appName, fileName))
+		}
+	}
+	paths = append(paths, filepath.Join("/etc", appName, fileName))
+
+	// Locations might coincide, e.g. if XDG_CONFIG_HOME is not set
+	unique := []string{}
+	seen := map[string]bool{}
+	for _, p := range paths {
+		if !seen[p] {
+			unique = append(unique, p)
+			seen[p] = true
+		}
+	}
+	return unique
+}
+
 // MergeFiles changes the default behavior of using the first file found to
 // load configuration. Instead all files that are available will be loaded and
 // unmarshalled into the configuration struct.
@@ -47,6 +148,21 @@ func MergeFiles(f ...bool) FileOption {
 	})
 }
 
+// ReverseMergeOrder changes the order files are merged in, if MergeFiles is
+// specified. By default files are unmarshalled in the order of the search
+// paths, so files found later take precedence. With ReverseMergeOrder files
+// are unmarshalled in reverse, so files found earlier take precedence,
+// consistent with loading only the first file found.
+func ReverseMergeOrder(f ...bool) FileOption {
+	return fileOptionAdapter(func(o *fileOptions) {
+		v := true
+		if len(f) > 0 {
+			v = f[0]
+		}
+		o.reverseMergeOrder = v
+	})
+}
+
 // IgnoreNotFound surpresses File from returning fs.ErrNotExist errors
 // effectively making the configuration file optional.
 func IgnoreNotFound(f ...bool) FileOption {
@@ -59,7 +175,9 @@ func IgnoreNotFound(f ...bool) FileOption {
 	})
 }
 
-// ExpandEnv expands environment variables in loaded configuration files.
+// ExpandEnv expands environment variables in loaded configuration files
+// using os.ExpandEnv, so undefined variables are replaced by an empty string.
+// See ExpandEnvStrict for a stricter alternative.
 func ExpandEnv(f ...bool) FileOption {
 	return fileOptionAdapter(func(o *fileOptions) {
 		v := true
@@ -70,6 +188,36 @@ func ExpandEnv(f ...bool) FileOption {
 	})
 }
 
+// ExpandEnvStrict expands environment variables in loaded configuration files
+// similar to a shell. Unlike ExpandEnv, referencing an undefined variable
+// results in an error. Additionally the following is supported:
+//  - $$ results in a literal $
+//  - ${VAR:-default} results in default, if VAR is undefined or empty
+//  - ${VAR-default} results in default, if VAR is undefined
+//  - ${VAR:?message} results in an error with message, if VAR is undefined
+//    or empty
+//  - ${VAR?message} results in an error with message, if VAR is undefined
+// Defaults can reference variables themselves, e.g. ${VAR:-${OTHER}}.
+func ExpandEnvStrict(f ...bool) FileOption {
+	return fileOptionAdapter(func(o *fileOptions) {
+		v := true
+		if len(f) > 0 {
+			v = f[0]
+		}
+		o.expandEnvStrict = v
+	})
+}
+
+// FromFS makes File read configuration files from fsys rather than the file
+// system of the operating system, e.g. files embedded using "go:embed".
+// As required by fs.FS, paths are slash-separated and must not be rooted,
+// e.g. "config/app.yaml".
+func FromFS(fsys fs.FS) FileOption {
+	return fileOptionAdapter(func(o *fileOptions) {
+		o.fsys = fsys
+	})
+}
+
 // UnmarshalFunc is a function that File can use to unmarshal data into a struct.
 // Compatible with the common signature provided by json.Unmarshal, yaml.Unmarshal and similar.
 type UnmarshalFunc func(data []byte, dst interface{}) error
@@ -82,7 +230,8 @@ type UnmarshalFunc func(data []byte, dst interface{}) error
 // using AppendFilePaths. File will check the existence of those files one by one
 // and load the first found.
 // If MergeFiles is specified, all files will be loaded and unmarshalled in the
-// order specified by the search paths.
+// order specified by the search paths, so values of later files take
+// precedence. The order can be reversed using ReverseMergeOrder.
 //
 // Simple standalone example:
 //  err := File("/etc/myapp/config.json", json.Unmarshal, IgnoreNotFound()).Process(&cfg)
@@ -93,52 +242,309 @@ type UnmarshalFunc func(data []byte, dst interface{}) error
 //  ).Process(&cfg)
 func File(filePath string, unmarshal UnmarshalFunc, opts ...FileOption) Loader {
 	o := fileOptions{
-		ignoreNotFound: false,
-		expandEnv:      false,
-		mergeFiles:     false,
-		filePaths:      []string{filePath},
+		ignoreNotFound:    false,
+		expandEnv:         false,
+		mergeFiles:        false,
+		reverseMergeOrder: false,
+		filePaths:         []searchPath{{path: filePath}},
 	}
 	for _, opt := range opts {
 		opt.apply(&o)
 	}
-	return LoaderFunc(func(dst interface{}) error {
-		// Okay, let's load the files
-		var (
-			fileData = map[string][]byte{}
-			err      error
-		)
+	return trackedLoaderFunc(func(dst interface{}, t *tracker) error {
+		return o.process(dst, t, staticUnmarshal(unmarshal), o.readFile)
+	})
+}
+
+// staticUnmarshal returns a function using unmarshal for all paths.
+func staticUnmarshal(unmarshal UnmarshalFunc) func(string) (UnmarshalFunc, error) {
+	return func(string) (UnmarshalFunc, error) {
+		return unmarshal, nil
+	}
+}
 
-		for _, fp := range o.filePaths {
-			var d []byte
-			d, err = ioutil.ReadFile(fp)
-			if err != nil && !os.IsNotExist(err) {
-				return err
+// Files implements a Loader similar to File, but the UnmarshalFunc is chosen
+// based on the extension of each file, see RegisterFormat. This allows to
+// search for configuration files of different formats.
+//
+// For example:
+//  RegisterFormat(".yaml", yaml.Unmarshal)
+//  RegisterFormat(".yml", yaml.Unmarshal)
+//  err := Files([]string{"./config.yaml", "./config.yml", "./config.json"}).Process(&cfg)
+func Files(filePaths []string, opts ...FileOption) Loader {
+	o := fileOptions{
+		filePaths: toSearchPaths(filePaths),
+	}
+	for _, opt := range opts {
+		opt.apply(&o)
+	}
+	return trackedLoaderFunc(func(dst interface{}, t *tracker) error {
+		return o.process(dst, t, formatFor, o.readFile)
+	})
+}
+
+// readerPath is the path used to refer to the io.Reader passed to Reader,
+// e.g. in errors or Report.
+const readerPath = "<reader>"
+
+// Reader implements a Loader, that reads configuration from r, e.g. os.Stdin,
+// and unmarshals it the same way as File. As r can only be read once, it
+// should only be processed once.
+// If r is nil, it is handled like a file, that does not exist, so options of
+// File, e.g. IgnoreNotFound or AppendFilePaths to fall back to files, are
+// supported as well.
+//
+// For example:
+//  err := Load(&cfg, Reader(os.Stdin, json.Unmarshal))
+func Reader(r io.Reader, unmarshal UnmarshalFunc, opts ...FileOption) Loader {
+	o := fileOptions{
+		filePaths: []searchPath{{path: readerPath}},
+	}
+	for _, opt := range opts {
+		opt.apply(&o)
+	}
+	return trackedLoaderFunc(func(dst interface{}, t *tracker) error {
+		return o.process(dst, t, staticUnmarshal(unmarshal), func(path string) ([]byte, error) {
+			if path != readerPath {
+				return o.readFile(path)
+			}
+			if r == nil {
+				return nil, fs.ErrNotExist
 			}
+			return ioutil.ReadAll(r)
+		})
+	})
+}
+
+// readFile reads the file at path from the configured file system.
+func (o *fileOptions) readFile(path string) ([]byte, error) {
+	if o.fsys != nil {
+		return fs.ReadFile(o.fsys, path)
+	}
+	return ioutil.ReadFile(path)
+}
+
+// process reads the configuration files using read and unmarshals them into
+// dst as configured using the UnmarshalFunc returned by unmarshalFor.
+func (o *fileOptions) process(dst interface{}, t *tracker, unmarshalFor func(path string) (UnmarshalFunc, error), read func(path string) ([]byte, error)) error {
+	// Okay, let's load the files
+	files := []loadedFile{}
+
+	filePaths, err := o.expandFilePaths()
+	if err != nil {
+		return err
+	}
+	for _, fp := range filePaths {
+		var d []byte
+		d, err = read(fp)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return &FileError{Path: fp, Err: err}
+		}
+		if err != nil {
+			continue
+		}
+		if o.expandEnvStrict {
+			expanded, err := expandEnvStrict(string(d), os.LookupEnv)
 			if err != nil {
-				continue
+				return &FileError{Path: fp, Err: fmt.Errorf("failed to expand environment variables: %w", err)}
 			}
-			if o.expandEnv {
-				d = []byte(os.ExpandEnv(string(d)))
+			d = []byte(expanded)
+		} else if o.expandEnv {
+			d = []byte(os.ExpandEnv(string(d)))
+		}
+		files = append(files, loadedFile{path: fp, data: d})
+		if !o.mergeFiles { // If we only want the first file we find, stop here
+			break
+		}
+	}
+
+	if o.ignoreNotFound && len(files) == 0 {
+		return nil
+	}
+	if len(files) == 0 {
+		if err == nil { // No paths at all
+			err = fs.ErrNotExist
+		}
+		return fmt.Errorf("no file loaded, last error was: %w", err)
+	}
+
+	if o.reverseMergeOrder {
+		for i, j := 0, len(files)-1; i < j; i, j = i+1, j-1 {
+			files[i], files[j] = files[j], files[i]
+		}
+	}
+
+	for _, f := range files {
+		unmarshal, err := unmarshalFor(f.path)
+		if err != nil {
+			return &FileError{Path: f.path, Err: err}
+		}
+		if err := unmarshal(f.data, dst); err != nil {
+			return &FileError{Path: f.path, Err: fmt.Errorf("failed to unmarshal: %w", err)}
+		}
+		if t != nil {
+			paths, err := unmarshalledFields(f.data, reflect.TypeOf(dst).Elem(), unmarshal)
+			if err != nil {
+				return &FileError{Path: f.path, Err: fmt.Errorf("failed to unmarshal: %w", err)}
 			}
-			fileData[fp] = d
-			if !o.mergeFiles { // If we only want the first file we find, stop here
-				break
+			for _, path := range paths {
+				t.track(path, Source{Kind: SourceFile, Name: f.path})
 			}
 		}
+	}
 
-		if o.ignoreNotFound && len(fileData) == 0 {
-			return nil
+	return nil
+}
+
+// expandFilePaths returns the paths of all configuration files, with
+// directories expanded to the matching files in lexical order.
+func (o *fileOptions) expandFilePaths() ([]string, error) {
+	paths := []string{}
+	for _, fp := range o.filePaths {
+		if fp.expand != nil {
+			paths = append(paths, fp.expand()...)
+			continue
 		}
-		if len(fileData) == 0 {
-			return fmt.Errorf("no file loaded, last error was: %w", err)
+		if fp.pattern == "" {
+			// An empty path is allowed, if only appended paths should be used
+			if fp.path != "" {
+				paths = append(paths, fp.path)
+			}
+			continue
 		}
-
-		for fp, d := range fileData {
-			if err := unmarshal(d, dst); err != nil {
-				return fmt.Errorf("failed to unmarshal '%s': %w", fp, err)
+		var (
+			matches []string
+			err     error
+		)
+		if o.fsys != nil {
+			matches, err = fs.Glob(o.fsys, path.Join(fp.path, fp.pattern))
+		} else {
+			matches, err = filepath.Glob(filepath.Join(fp.path, fp.pattern))
+		}
+		if err != nil {
+			return nil, &FileError{Path: fp.path, Err: fmt.Errorf("invalid pattern '%s': %w", fp.pattern, err)}
+		}
+		sort.Strings(matches)
+		for _, m := range matches {
+			// Only files are of interest, not directories matching the pattern
+			var info fs.FileInfo
+			if o.fsys != nil {
+				info, err = fs.Stat(o.fsys, m)
+			} else {
+				info, err = os.Stat(m)
+			}
+			if err == nil && !info.IsDir() {
+				paths = append(paths, m)
 			}
 		}
+	}
+	return paths, nil
+}
 
-		return nil
+// loadedFile holds the contents of a configuration file read from path.
+type loadedFile struct {
+	path string
+	data []byte
+}
+
+// unmarshalledFields returns the paths of all fields of struct-type typ, that
+// are set when unmarshalling data.
+// As unmarshal functions do not report which fields they set, data is
+// unmarshalled twice: once into a zero value and once into a value with all
+// fields set to non-zero placeholders. A field was set if it is non-zero in
+// the former or no longer equals its placeholder in the latter, which also
+// catches explicitly set zero values.
+func unmarshalledFields(data []byte, typ reflect.Type, unmarshal UnmarshalFunc) ([][]string, error) {
+	zero := reflect.New(typ)
+	if err := unmarshal(data, zero.Interface()); err != nil {
+		return nil, err
+	}
+	placeholder := reflect.New(typ)
+	fillPlaceholders(placeholder.Elem(), map[reflect.Type]bool{})
+	if err := unmarshal(data, placeholder.Interface()); err != nil {
+		return nil, err
+	}
+	// Unmarshalling might mutate slices and maps in-place, so create a fresh
+	// reference to compare to
+	reference := reflect.New(typ)
+	fillPlaceholders(reference.Elem(), map[reflect.Type]bool{})
+
+	unchanged := map[string]bool{}
+	placeholders := map[string]reflect.Value{}
+	visitLeaves(reference.Elem(), func(path []string, _ reflect.StructField, v reflect.Value) {
+		placeholders[strings.Join(path, ".")] = v
+	})
+	visitLeaves(placeholder.Elem(), func(path []string, _ reflect.StructField, v reflect.Value) {
+		key := strings.Join(path, ".")
+		unchanged[key] = reflect.DeepEqual(v.Interface(), placeholders[key].Interface())
+	})
+	paths := [][]string{}
+	visitLeaves(zero.Elem(), func(path []string, _ reflect.StructField, v reflect.Value) {
+		if !v.IsZero() || !unchanged[strings.Join(path, ".")] {
+			paths = append(paths, path)
+		}
 	})
+	return paths, nil
+}
+
+// fillPlaceholders sets all exported fields of struct v to non-zero values,
+// allocating pointers to structs along the way. Fields of kinds without
+// meaningful placeholders (e.g. interfaces) are left untouched.
+func fillPlaceholders(v reflect.Value, seen map[reflect.Type]bool) {
+	t := v.Type()
+	if seen[t] {
+		return
+	}
+	seen[t] = true
+	defer delete(seen, t)
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).PkgPath != "" { // unexported
+			continue
+		}
+		f := v.Field(i)
+		if isNestedStruct(f.Type()) {
+			if f.Kind() == reflect.Ptr {
+				f.Set(reflect.New(f.Type().Elem()))
+				f = f.Elem()
+			}
+			fillPlaceholders(f, seen)
+			continue
+		}
+		setPlaceholder(f, seen)
+	}
+}
+
+// setPlaceholder sets v to a non-zero value. Structs already being filled, as
+// recorded by seen, are left untouched to stop recursive types, e.g. a struct
+// containing a slice of itself.
+func setPlaceholder(v reflect.Value, seen map[reflect.Type]bool) {
+	switch v.Kind() {
+	case reflect.Bool:
+		v.SetBool(true)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v.SetInt(1)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		v.SetUint(1)
+	case reflect.Float32, reflect.Float64:
+		v.SetFloat(1)
+	case reflect.Complex64, reflect.Complex128:
+		v.SetComplex(1)
+	case reflect.String:
+		v.SetString("\x00")
+	case reflect.Slice:
+		v.Set(reflect.MakeSlice(v.Type(), 1, 1))
+		setPlaceholder(v.Index(0), seen)
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			setPlaceholder(v.Index(i), seen)
+		}
+	case reflect.Map:
+		v.Set(reflect.MakeMap(v.Type()))
+		v.SetMapIndex(reflect.Zero(v.Type().Key()), reflect.Zero(v.Type().Elem()))
+	case reflect.Ptr:
+		v.Set(reflect.New(v.Type().Elem()))
+		setPlaceholder(v.Elem(), seen)
+	case reflect.Struct:
+		fillPlaceholders(v, seen)
+	}
 }
@@ -6,7 +6,10 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
+	"testing/fstest"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -55,6 +58,31 @@ func TestFileOptions(t *testing.T) {
 		assert.Equal("b", result.B)
 		assert.Equal("c", result.C)
 	})
+	t.Run("MergeOrder", func(t *testing.T) {
+		fd := filepath.Join(pc, "g.json")
+		err = os.WriteFile(fd, []byte(`{ "a": "g", "b": "g" }`), 0600)
+		require.NoError(err)
+		defer os.Remove(fd)
+		// Repeat a few times as the order used to be random
+		for i := 0; i < 10; i++ {
+			result := TestConfigFileOptions{}
+			err = File(fa, json.Unmarshal,
+				AppendFilePaths(fb, fd),
+				MergeFiles(),
+			).Process(&result)
+			require.NoError(err)
+			assert.Equal(TestConfigFileOptions{A: "g", B: "g"}, result)
+
+			result = TestConfigFileOptions{}
+			err = File(fa, json.Unmarshal,
+				AppendFilePaths(fb, fd),
+				MergeFiles(),
+				ReverseMergeOrder(),
+			).Process(&result)
+			require.NoError(err)
+			assert.Equal(TestConfigFileOptions{A: "a", B: "b"}, result)
+		}
+	})
 	t.Run("FirstFound", func(t *testing.T) {
 		result := TestConfigFileOptions{}
 		err = File(fa, json.Unmarshal,
@@ -79,3 +107,209 @@ func TestFileIgnoreNotFound(t *testing.T) {
 		require.Error(t, err)
 	})
 }
+
+func TestUnmarshalledFields(t *testing.T) {
+	assert := assert.New(t)
+	type nested struct {
+		D bool `json:"d"`
+		E bool `json:"e"`
+	}
+	type config struct {
+		A string            `json:"a"`
+		B int               `json:"b"`
+		C map[string]string `json:"c"`
+		N *nested           `json:"n"`
+		X []int             `json:"x"`
+	}
+	paths, err := unmarshalledFields([]byte(`{ "a": "", "b": 1, "c": {}, "n": { "e": false } }`), reflect.TypeOf(config{}), json.Unmarshal)
+	assert.NoError(err)
+	assert.Equal([][]string{{"A"}, {"B"}, {"C"}, {"N", "E"}}, paths)
+}
+
+type testTreeNode struct {
+	Name     string         `json:"name"`
+	Children []testTreeNode `json:"children"`
+}
+
+func TestUnmarshalledFieldsRecursive(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+	fsys := fstest.MapFS{
+		"tree.json": &fstest.MapFile{Data: []byte(`{ "tree": { "name": "root", "children": [{ "name": "leaf" }] } }`)},
+	}
+	result := struct {
+		Tree testTreeNode `json:"tree"`
+	}{}
+	report, err := LoadWithReport(&result, File("tree.json", json.Unmarshal, FromFS(fsys)))
+	require.NoError(err)
+	assert.Equal("root", result.Tree.Name)
+	require.Len(result.Tree.Children, 1)
+	assert.Equal("leaf", result.Tree.Children[0].Name)
+	assert.Equal(Source{Kind: SourceFile, Name: "tree.json"}, report["Tree.Children"])
+}
+
+func TestFileFromFS(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+	fsys := fstest.MapFS{
+		"config/a.json": &fstest.MapFile{Data: []byte(`{ "a": "a", "b": "a" }`)},
+		"config/b.json": &fstest.MapFile{Data: []byte(`{ "b": "$FILE_FROM_FS_B" }`)},
+	}
+	os.Setenv("FILE_FROM_FS_B", "b")
+
+	result := TestConfigFileOptions{}
+	report, err := LoadWithReport(&result, File("config/missing.json", json.Unmarshal,
+		AppendFilePaths("config/a.json", "config/b.json"),
+		MergeFiles(),
+		ExpandEnv(),
+		FromFS(fsys),
+	))
+	require.NoError(err)
+	assert.Equal(TestConfigFileOptions{A: "a", B: "b"}, result)
+	assert.Equal(Source{Kind: SourceFile, Name: "config/b.json"}, report["B"])
+
+	err = File("config/missing.json", json.Unmarshal, FromFS(fsys)).Process(&result)
+	assert.ErrorIs(err, os.ErrNotExist)
+	err = File("config/missing.json", json.Unmarshal, FromFS(fsys), IgnoreNotFound()).Process(&result)
+	assert.NoError(err)
+}
+
+func TestReader(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	result := TestConfigFileOptions{}
+	report, err := LoadWithReport(&result, Reader(strings.NewReader(`{ "a": "a" }`), json.Unmarshal))
+	require.NoError(err)
+	assert.Equal("a", result.A)
+	assert.Equal(Source{Kind: SourceFile, Name: readerPath}, report["A"])
+
+	err = Reader(strings.NewReader(`{ "a": `), json.Unmarshal).Process(&result)
+	var fileErr *FileError
+	require.ErrorAs(err, &fileErr)
+	assert.Equal(readerPath, fileErr.Path)
+
+	err = Reader(nil, json.Unmarshal).Process(&result)
+	assert.ErrorIs(err, os.ErrNotExist)
+	err = Reader(nil, json.Unmarshal, IgnoreNotFound()).Process(&result)
+	assert.NoError(err)
+	fsys := fstest.MapFS{"b.json": &fstest.MapFile{Data: []byte(`{ "b": "b" }`)}}
+	err = Reader(nil, json.Unmarshal, AppendFilePaths("b.json"), FromFS(fsys)).Process(&result)
+	require.NoError(err)
+	assert.Equal("b", result.B)
+}
+
+func TestFileDirectory(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	dir := t.TempDir()
+	confd := filepath.Join(dir, "conf.d")
+	require.NoError(os.MkdirAll(filepath.Join(confd, "sub.json"), 0700))
+	files := map[string]string{
+		"config.json":       `{ "a": "config", "b": "config", "c": "config" }`,
+		"conf.d/10-b.json":  `{ "b": "10", "c": "10" }`,
+		"conf.d/99-c.json":  `{ "c": "99" }`,
+		"conf.d/50-ignored": `{ "c": "ignored" }`,
+		"conf.d/sub.json/x": `{ "c": "ignored" }`,
+	}
+	fsys := fstest.MapFS{}
+	for name, data := range files {
+		require.NoError(os.WriteFile(filepath.Join(dir, name), []byte(data), 0600))
+		fsys[name] = &fstest.MapFile{Data: []byte(data)}
+	}
+	// Files are expanded when processed
+	loader := File(filepath.Join(dir, "config.json"), json.Unmarshal, Directory(confd, "*.json"), MergeFiles())
+	require.NoError(os.WriteFile(filepath.Join(confd, "20-a.json"), []byte(`{ "a": "20" }`), 0600))
+
+	expected := TestConfigFileOptions{A: "20", B: "10", C: "99"}
+	result := TestConfigFileOptions{}
+	require.NoError(loader.Process(&result))
+	assert.Equal(expected, result)
+
+	expected.A = "config"
+	result = TestConfigFileOptions{}
+	err := File("config.json", json.Unmarshal, Directory("conf.d", "*.json"), MergeFiles(), FromFS(fsys)).Process(&result)
+	require.NoError(err)
+	assert.Equal(expected, result)
+
+	err = File("config.json", json.Unmarshal, Directory("conf.d", "[")).Process(&result)
+	assert.Error(err)
+}
+
+func TestFileStandardPaths(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	// Locations are determined when processed, so loaders are created first
+	o := fileOptions{}
+	StandardPaths("myapp", "config.json").apply(&o)
+	first := File("", json.Unmarshal, StandardPaths("myapp", "config.json"))
+	merged := File("", json.Unmarshal, StandardPaths("myapp", "config.json"), MergeFiles(), ReverseMergeOrder())
+
+	dir := t.TempDir()
+	t.Setenv("HOME", filepath.Join(dir, "home"))
+	t.Setenv("USERPROFILE", filepath.Join(dir, "home"))
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(dir, "xdg"))
+	t.Setenv("XDG_CONFIG_DIRS", filepath.Join(dir, "a")+string(filepath.ListSeparator)+filepath.Join(dir, "b"))
+
+	expected := []string{
+		"config.json",
+		filepath.Join(dir, "xdg", "myapp", "config.json"),
+	}
+	// The user configuration directory depends on the platform and might
+	// coincide with XDG_CONFIG_HOME
+	if configDir, err := os.UserConfigDir(); err == nil && configDir != filepath.Join(dir, "xdg") {
+		expected = append(expected, filepath.Join(configDir, "myapp", "config.json"))
+	}
+	expected = append(expected,
+		filepath.Join(dir, "home", ".config", "myapp", "config.json"),
+		filepath.Join(dir, "a", "myapp", "config.json"),
+		filepath.Join(dir, "b", "myapp", "config.json"),
+		filepath.Join("/etc", "myapp", "config.json"),
+	)
+	paths, err := o.expandFilePaths()
+	require.NoError(err)
+	assert.Equal(expected, paths)
+
+	for name, data := range map[string]string{
+		"xdg/myapp/config.json":          `{ "a": "xdg" }`,
+		"home/.config/myapp/config.json": `{ "a": "home", "c": "home" }`,
+		"b/myapp/config.json":            `{ "a": "b", "b": "b", "c": "b" }`,
+	} {
+		p := filepath.Join(dir, name)
+		require.NoError(os.MkdirAll(filepath.Dir(p), 0700))
+		require.NoError(os.WriteFile(p, []byte(data), 0600))
+	}
+	result := TestConfigFileOptions{}
+	require.NoError(first.Process(&result))
+	assert.Equal(TestConfigFileOptions{A: "xdg"}, result)
+
+	result = TestConfigFileOptions{}
+	require.NoError(merged.Process(&result))
+	assert.Equal(TestConfigFileOptions{A: "xdg", B: "b", C: "home"}, result)
+
+	err = File("", json.Unmarshal).Process(&result)
+	assert.ErrorIs(err, os.ErrNotExist)
+}
+
+func TestFileExpandEnvStrict(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+	fsys := fstest.MapFS{
+		"valid.json":   &fstest.MapFile{Data: []byte(`{ "a": "${FILE_EXPAND_A}", "b": "p4$$w0rd", "c": "${FILE_EXPAND_C:-c}" }`)},
+		"invalid.json": &fstest.MapFile{Data: []byte(`{ "a": "${FILE_EXPAND_UNDEFINED}" }`)},
+	}
+	t.Setenv("FILE_EXPAND_A", "a")
+
+	result := TestConfigFileOptions{}
+	err := File("valid.json", json.Unmarshal, ExpandEnvStrict(), FromFS(fsys)).Process(&result)
+	require.NoError(err)
+	assert.Equal(TestConfigFileOptions{A: "a", B: "p4$w0rd", C: "c"}, result)
+
+	err = File("invalid.json", json.Unmarshal, ExpandEnvStrict(), FromFS(fsys)).Process(&result)
+	var fileErr *FileError
+	require.ErrorAs(err, &fileErr)
+	assert.Equal("invalid.json", fileErr.Path)
+	assert.Contains(err.Error(), "FILE_EXPAND_UNDEFINED")
+}
@@ -82,9 +82,9 @@ func FlagSet(flags *pflag.FlagSet, opts ...FlagSetOption) Loader {
 	for _, opt := range opts {
 		opt.apply(&o)
 	}
-	return LoaderFunc(func(dst interface{}) error {
+	return trackedLoaderFunc(func(dst interface{}, t *tracker) error {
 		flagMap := listFlags(flags, o.includeUnchanged)
-		return StructWalk(dst, func(path []string, field reflect.StructField) (interface{}, error) {
+		return structWalk(dst, func(path []string, field reflect.StructField) (interface{}, error) {
 			name := o.nameGetter(path)
 			if tag, ok := field.Tag.Lookup(o.tag); ok {
 				params := strings.Split(tag, ",")
@@ -93,13 +93,16 @@ func FlagSet(flags *pflag.FlagSet, opts ...FlagSetOption) Loader {
 			if name == "" {
 				return nil, nil
 			}
+			if flags.Lookup(name) != nil {
+				t.consider(path, Source{Kind: SourceFlag, Name: name})
+			}
 			if val, ok := flagMap[name]; ok {
+				t.track(path, Source{Kind: SourceFlag, Name: name})
 				// Mismatch is handled by StructWalk
 				return val, nil
 			}
 			return nil, nil
-		})
-
+		}, walkOptions{collectErrors: t.collecting()})
 	})
 }
 
@@ -185,13 +188,10 @@ func listFlags(flags *pflag.FlagSet, includeUnchanged bool) map[string]interface
 		default:
 			v = flag.Value.String()
 		}
-		t := reflect.TypeOf(v)
-		// If the flag has the corresponding zero-type set, do not set it
-		if t.Comparable() && v == reflect.Zero(t).Interface() {
-			return
-		}
-		// If the flag is an empty slice, do not set it
-		if t.Kind() == reflect.Slice && reflect.ValueOf(v).Len() == 0 {
+		// If an unchanged flag has the corresponding zero-type set or is an
+		// empty slice, do not set it. Changed flags on the other hand were
+		// explicitly set, so even zero values have to be set.
+		if !flag.Changed && isEmptyValue(reflect.ValueOf(v)) {
 			return
 		}
 		flagMap[flag.Name] = v
@@ -0,0 +1,49 @@
+package copre
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+var (
+	formatsMu sync.RWMutex
+	formats   = map[string]UnmarshalFunc{
+		".json": json.Unmarshal,
+	}
+)
+
+// RegisterFormat registers unmarshal for files with the extension ext, e.g.
+// ".yaml", which is used by Files. Extensions are case-insensitive and a
+// previously registered function for the same extension is replaced.
+// By default only ".json" is registered.
+//
+// For example:
+//  RegisterFormat(".toml", toml.Unmarshal)
+func RegisterFormat(ext string, unmarshal UnmarshalFunc) {
+	formatsMu.Lock()
+	defer formatsMu.Unlock()
+	formats[normalizeExt(ext)] = unmarshal
+}
+
+// formatFor returns the UnmarshalFunc registered for the extension of path.
+func formatFor(path string) (UnmarshalFunc, error) {
+	ext := normalizeExt(filepath.Ext(path))
+	formatsMu.RLock()
+	defer formatsMu.RUnlock()
+	unmarshal, ok := formats[ext]
+	if !ok {
+		return nil, fmt.Errorf("%w: no format registered for extension '%s'", ErrUnsupportedFormat, ext)
+	}
+	return unmarshal, nil
+}
+
+func normalizeExt(ext string) string {
+	ext = strings.ToLower(ext)
+	if !strings.HasPrefix(ext, ".") {
+		ext = "." + ext
+	}
+	return ext
+}
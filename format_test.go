@@ -0,0 +1,49 @@
+package copre
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// unmarshalTestKV unmarshals lines of the form "key=value" into
+// TestConfigFileOptions, standing in for formats not supported by the
+// standard library.
+func unmarshalTestKV(data []byte, dst interface{}) error {
+	m := map[string]string{}
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		kv := strings.SplitN(line, "=", 2)
+		m[kv[0]] = kv[1]
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, dst)
+}
+
+func TestFiles(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+	RegisterFormat("KV", unmarshalTestKV)
+	fsys := fstest.MapFS{
+		"a.json": &fstest.MapFile{Data: []byte(`{ "a": "a", "b": "a" }`)},
+		"b.kv":   &fstest.MapFile{Data: []byte("b=b\nc=b")},
+		"c.ini":  &fstest.MapFile{Data: []byte("c=c")},
+	}
+
+	result := TestConfigFileOptions{}
+	err := Files([]string{"a.json", "b.kv", "missing.yaml"}, MergeFiles(), FromFS(fsys)).Process(&result)
+	require.NoError(err)
+	assert.Equal(TestConfigFileOptions{A: "a", B: "b", C: "b"}, result)
+
+	err = Files([]string{"missing.json", "c.ini"}, FromFS(fsys)).Process(&result)
+	var fileErr *FileError
+	require.ErrorAs(err, &fileErr)
+	assert.Equal("c.ini", fileErr.Path)
+	assert.ErrorIs(err, ErrUnsupportedFormat)
+}
@@ -1,11 +1,16 @@
 module github.com/trevex/copre
 
-go 1.16
+go 1.18
 
 require (
 	github.com/fatih/camelcase v1.0.0
-	github.com/imdario/mergo v0.3.12
 	github.com/mitchellh/reflectwalk v1.0.2
 	github.com/spf13/pflag v1.0.5
 	github.com/stretchr/testify v1.7.0
 )
+
+require (
+	github.com/davecgh/go-spew v1.1.0 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	gopkg.in/yaml.v3 v3.0.0-20200313102051-9f266ea9e77c // indirect
+)
@@ -1,10 +1,12 @@
 package copre
 
 import (
+	"errors"
 	"fmt"
 	"reflect"
-
-	"github.com/imdario/mergo"
+	"sort"
+	"strconv"
+	"strings"
 )
 
 // Loader is the interface that needs to be implemented to be able to load
@@ -24,31 +26,310 @@ func (fn LoaderFunc) Process(dst interface{}) error {
 	return fn(dst)
 }
 
+// loadTag is the struct-tag used to specify options of Load for individual
+// fields, e.g. `copre:",merge=append,required"`.
+const loadTag = "copre"
+
+type loadOptions struct {
+	sliceStrategy MergeStrategy
+	mapStrategy   MergeStrategy
+	collectErrors bool
+}
+
+// LoadOption configures how Load merges the values populated by loaders.
+type LoadOption interface {
+	apply(*loadOptions)
+}
+
+type loadOptionAdapter func(*loadOptions)
+
+func (c loadOptionAdapter) apply(o *loadOptions) {
+	c(o)
+}
+
+// MergeSlices sets the MergeStrategy used for all slices, that do not specify
+// a strategy using the "copre"-tag. Supported are MergeReplace (default),
+// MergeAppend and MergeAppendUnique.
+func MergeSlices(strategy MergeStrategy) LoadOption {
+	return loadOptionAdapter(func(o *loadOptions) {
+		o.sliceStrategy = strategy
+	})
+}
+
+// MergeMaps sets the MergeStrategy used for all maps, that do not specify
+// a strategy using the "copre"-tag. Supported are MergeReplace (default) and
+// MergeDeep.
+func MergeMaps(strategy MergeStrategy) LoadOption {
+	return loadOptionAdapter(func(o *loadOptions) {
+		o.mapStrategy = strategy
+	})
+}
+
+// CollectErrors changes the default behavior of returning the first error
+// encountered. Instead all loaders and fields are processed and all errors
+// are returned as *MultiError. Errors related to specific fields are of type
+// *FieldError and contain the path, source and raw value.
+// Fields that could be populated successfully are still merged.
+//
+// For example:
+//  _, err := LoadWithOptions(&cfg, loaders, CollectErrors())
+//  var multiErr *MultiError
+//  if errors.As(err, &multiErr) {
+//    for _, fieldErr := range multiErr.FieldErrors() {
+//      fmt.Printf("%s: %s\n", fieldErr.Source, fieldErr.Err)
+//    }
+//  }
+func CollectErrors(f ...bool) LoadOption {
+	return loadOptionAdapter(func(o *loadOptions) {
+		v := true
+		if len(f) > 0 {
+			v = f[0]
+		}
+		o.collectErrors = v
+	})
+}
+
 // Load is the central function tying all the building blocks together.
 // It allows the composition of the loader precedence.
 // For a given pointer to struct dst, an empty instantiation of the same type
-// is create for each loader. Each loader populates its copy and all copies are
-// merged into dst in the specified order.
+// is create for each loader. Each loader populates its copy and the fields
+// populated by each loader are merged into dst in the specified order.
+// As only populated fields are merged, later loaders can explicitly set zero
+// values, e.g. an environment variable set to "false" overrides true set by a
+// previous loader.
+// Loaders not provided by this package can not report the fields they
+// populated, so only their non-zero values and non-empty slices and maps
+// are merged.
+//
+// Fields can be marked as required using the "copre"-tag, e.g.
+// `copre:",required"`, or the "env"-tag, e.g. `env:"DB_URL,required"`. If no
+// loader populated a required field and no non-zero value was set prior to
+// loading, a *MissingFieldsError is returned.
 //
 // See the README for several examples.
 func Load(dst interface{}, loaders ...Loader) error {
+	_, err := LoadWithOptions(dst, loaders)
+	return err
+}
+
+// LoadWithReport behaves like Load, but additionally returns a Report
+// containing the source of every field's final value.
+// The loaders of this package report the environment variable, flag name or
+// file path a value was retrieved from. Values populated by other Loader
+// implementations are reported as SourceLoader with the index of the
+// loader as name.
+//
+// For example:
+//  report, err := LoadWithReport(&cfg, File("./config.json", json.Unmarshal), Env(WithPrefix("MYAPP")))
+//  // ...
+//  fmt.Println(report["Port"]) // prints "env MYAPP_PORT"
+func LoadWithReport(dst interface{}, loaders ...Loader) (Report, error) {
+	return LoadWithOptions(dst, loaders)
+}
+
+// LoadWithOptions behaves like LoadWithReport, but allows to configure how
+// values are merged using options.
+//
+// By default slices and maps populated by a loader replace previous values.
+// For example to append slices instead:
+//  report, err := LoadWithOptions(&cfg, []Loader{
+//    File("/etc/myapp/config.json", json.Unmarshal),
+//    Env(WithPrefix("MYAPP")),
+//  }, MergeSlices(MergeAppend))
+// The strategy can also be set for individual fields using the "copre"-tag:
+//  AllowedOrigins []string `copre:",merge=unique"`
+func LoadWithOptions(dst interface{}, loaders []Loader, opts ...LoadOption) (Report, error) {
+	o := loadOptions{
+		sliceStrategy: MergeReplace,
+		mapStrategy:   MergeReplace,
+		collectErrors: false,
+	}
+	for _, opt := range opts {
+		opt.apply(&o)
+	}
+	if err := o.sliceStrategy.validate(reflect.Slice); err != nil {
+		return nil, err
+	}
+	if err := o.mapStrategy.validate(reflect.Map); err != nil {
+		return nil, err
+	}
 	// Make sure dst is a pointer to struct
 	v := reflect.ValueOf(dst)
 	if v.Kind() != reflect.Ptr {
-		return fmt.Errorf("Expected destination to be pointer not %s", v.Kind())
+		return nil, fmt.Errorf("Expected destination to be pointer not %s", v.Kind())
 	}
 	v = v.Elem()
 	if v.Kind() != reflect.Struct {
-		return fmt.Errorf("Expected destination to point to struct not %s", v.Kind())
+		return nil, fmt.Errorf("Expected destination to point to struct not %s", v.Kind())
+	}
+	report := Report{}
+	required := map[string]bool{}
+	candidates := map[string][]Source{}
+	visitLeaves(v, func(path []string, _ reflect.StructField, _ reflect.Value) {
+		report[strings.Join(path, ".")] = Source{Kind: SourcePreset}
+	})
+	// Nested structs can be required as well
+	visitFields(v, func(path []string, sf reflect.StructField, _ reflect.Value) {
+		for _, param := range loadTagParams(sf) {
+			if param == "required" {
+				required[strings.Join(path, ".")] = true
+			}
+		}
+	})
+	errs := []error{}
+	for i, l := range loaders {
+		tmp := reflect.New(v.Type())
+		t := newTracker()
+		t.collectErrors = o.collectErrors
+		var err error
+		if tl, ok := l.(trackedLoader); ok {
+			err = tl.processTracked(tmp.Interface(), t)
+		} else {
+			err = l.Process(tmp.Interface())
+			// We can not know which fields were populated, so let's assume
+			// all non-empty ones were
+			visitLeaves(tmp.Elem(), func(path []string, _ reflect.StructField, f reflect.Value) {
+				if !isEmptyValue(f) {
+					t.track(path, Source{Kind: SourceLoader, Name: strconv.Itoa(i)})
+				}
+			})
+		}
+		if err != nil {
+			// Fields that failed were not populated, but we might know the source
+			for _, e := range errorList(err) {
+				var fieldErr *FieldError
+				if errors.As(e, &fieldErr) {
+					if fieldErr.Source.Kind == "" {
+						fieldErr.Source = t.sources[fieldErr.Path]
+					}
+					delete(t.sources, fieldErr.Path)
+				}
+			}
+			if !o.collectErrors {
+				return nil, err
+			}
+			errs = append(errs, errorList(err)...)
+			// Unless only individual fields failed, nothing was populated
+			var multiErr *MultiError
+			if !errors.As(err, &multiErr) {
+				continue
+			}
+		}
+		keys := make([]string, 0, len(t.sources))
+		for key := range t.sources {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			path := strings.Split(key, ".")
+			dstField, sf := fieldByPath(v, path)
+			srcField, _ := fieldByPath(tmp.Elem(), path)
+			strategy, err := o.strategyFor(sf)
+			if err != nil {
+				err = &FieldError{
+					Path:       key,
+					Source:     t.sources[key],
+					TargetType: sf.Type,
+					Err:        fmt.Errorf("invalid merge strategy: %w", err),
+				}
+				if !o.collectErrors {
+					return nil, err
+				}
+				errs = append(errs, err)
+				continue
+			}
+			mergeValue(dstField, srcField, strategy)
+			// Loaders might populate nested structs as a whole, e.g. Env
+			// decoding JSON, so previous sources of nested fields are stale
+			for k := range report {
+				if strings.HasPrefix(k, key+".") {
+					delete(report, k)
+				}
+			}
+			report[key] = t.sources[key]
+		}
+		for key, srcs := range t.candidates {
+			candidates[key] = append(candidates[key], srcs...)
+		}
+		for key := range t.required {
+			required[key] = true
+		}
+	}
+	// Finally make sure all required fields were populated
+	missing := []MissingField{}
+	visitFields(v, func(path []string, _ reflect.StructField, f reflect.Value) {
+		key := strings.Join(path, ".")
+		if required[key] && !report.populated(key) && f.IsZero() {
+			missing = append(missing, MissingField{Path: key, Candidates: candidates[key]})
+		}
+	})
+	if len(missing) > 0 {
+		errs = append(errs, &MissingFieldsError{Fields: missing})
+	}
+	if len(errs) == 0 {
+		return report, nil
 	}
-	for _, l := range loaders {
-		tmp := reflect.New(v.Type()).Interface()
-		if err := l.Process(tmp); err != nil {
-			return err
+	if !o.collectErrors {
+		return report, errs[0]
+	}
+	return report, &MultiError{Errors: errs}
+}
+
+// strategyFor returns the MergeStrategy for field sf, which is either
+// specified by the "copre"-tag or the default for its kind.
+func (o *loadOptions) strategyFor(sf reflect.StructField) (MergeStrategy, error) {
+	strategy := MergeReplace
+	switch sf.Type.Kind() {
+	case reflect.Slice:
+		strategy = o.sliceStrategy
+	case reflect.Map:
+		strategy = o.mapStrategy
+	}
+	for _, param := range loadTagParams(sf) {
+		if strings.HasPrefix(param, "merge=") {
+			strategy = MergeStrategy(strings.TrimPrefix(param, "merge="))
 		}
-		if err := mergo.Merge(dst, tmp, mergo.WithOverride); err != nil {
-			return err
+	}
+	return strategy, strategy.validate(sf.Type.Kind())
+}
+
+// loadTagParams returns the options specified by the "copre"-tag of field sf.
+func loadTagParams(sf reflect.StructField) []string {
+	tag, ok := sf.Tag.Lookup(loadTag)
+	if !ok {
+		return nil
+	}
+	return strings.Split(tag, ",")[1:]
+}
+
+// fieldByPath returns the field at path of struct v and its description.
+// Nil pointers to structs are allocated along the way if possible, otherwise
+// they are treated as pointing to a zero value.
+func fieldByPath(v reflect.Value, path []string) (reflect.Value, reflect.StructField) {
+	var sf reflect.StructField
+	for _, name := range path {
+		if v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				if v.CanSet() {
+					v.Set(reflect.New(v.Type().Elem()))
+				} else {
+					v = reflect.New(v.Type().Elem())
+				}
+			}
+			v = v.Elem()
 		}
+		sf, _ = v.Type().FieldByName(name)
+		v = v.FieldByName(name)
+	}
+	return v, sf
+}
+
+// isEmptyValue reports whether v is considered empty when merging, which is
+// the case for zero values as well as empty slices and maps.
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Slice, reflect.Map:
+		return v.Len() == 0
 	}
-	return nil
+	return v.IsZero()
 }
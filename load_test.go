@@ -2,6 +2,7 @@ package copre
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -139,3 +140,205 @@ func TestLoaderPrecendence(t *testing.T) {
 		})
 	}
 }
+
+type TestConfigReport struct {
+	A string `json:"a" env:"A" flag:"a"`
+	B string `json:"b" env:"B" flag:"b"`
+	C string `json:"c" env:"C" flag:"c"`
+	D string `json:"d" env:"D" flag:"d"`
+	E struct {
+		F int
+	}
+}
+
+func TestLoadWithReport(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	tf, err := ioutil.TempFile("", "test")
+	require.NoError(err)
+	defer os.Remove(tf.Name())
+	_, err = tf.WriteString(`{ "a": "file", "b": "file" }`)
+	require.NoError(err)
+
+	f := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	f.String("b", "", "")
+	f.String("c", "", "")
+	require.NoError(f.Parse([]string{"--b=flag", "--c=flag"}))
+
+	os.Setenv("LOAD_WITH_REPORT_C", "env")
+
+	result := TestConfigReport{D: "struct"}
+	report, err := LoadWithReport(&result,
+		File(tf.Name(), json.Unmarshal),
+		FlagSet(f),
+		Env(WithPrefix("LOAD_WITH_REPORT")),
+		LoaderFunc(func(dst interface{}) error {
+			dst.(*TestConfigReport).E.F = 1
+			return nil
+		}),
+	)
+	require.NoError(err)
+	assert.Equal(Report{
+		"A":   Source{Kind: SourceFile, Name: tf.Name()},
+		"B":   Source{Kind: SourceFlag, Name: "b"},
+		"C":   Source{Kind: SourceEnv, Name: "LOAD_WITH_REPORT_C"},
+		"D":   Source{Kind: SourcePreset},
+		"E.F": Source{Kind: SourceLoader, Name: "3"},
+	}, report)
+	assert.Equal("env LOAD_WITH_REPORT_C", report["C"].String())
+}
+
+type TestConfigZeroValues struct {
+	Debug   bool     `json:"debug" env:"DEBUG" flag:"debug"`
+	Port    int      `json:"port" env:"PORT"`
+	Name    string   `json:"name" env:"NAME"`
+	Origins []string `json:"origins"`
+}
+
+func TestLoadZeroValues(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	tf, err := ioutil.TempFile("", "test")
+	require.NoError(err)
+	defer os.Remove(tf.Name())
+	_, err = tf.WriteString(`{ "debug": true, "port": 8080, "name": "file", "origins": [] }`)
+	require.NoError(err)
+
+	os.Setenv("LOAD_ZERO_VALUES_DEBUG", "false")
+	os.Setenv("LOAD_ZERO_VALUES_PORT", "0")
+	os.Setenv("LOAD_ZERO_VALUES_NAME", "")
+
+	result := TestConfigZeroValues{Origins: []string{"preset"}}
+	err = Load(&result,
+		File(tf.Name(), json.Unmarshal),
+		Env(WithPrefix("LOAD_ZERO_VALUES")),
+	)
+	require.NoError(err)
+	assert.Equal(TestConfigZeroValues{Origins: []string{}}, result)
+
+	f := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	f.Bool("debug", true, "")
+	require.NoError(f.Parse([]string{"--debug=false"}))
+	result = TestConfigZeroValues{Debug: true}
+	err = Load(&result, FlagSet(f))
+	require.NoError(err)
+	assert.False(result.Debug)
+}
+
+type TestConfigRequired struct {
+	A string `env:"A,required"`
+	B string `flag:"b" copre:",required"`
+	C string `copre:",required"`
+	D string `env:"D" copre:",required"`
+	E string `env:"E,required"`
+}
+
+func TestLoadRequired(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	f := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	f.String("b", "", "")
+	require.NoError(f.Parse([]string{}))
+	os.Setenv("LOAD_REQUIRED_D", "env")
+
+	result := TestConfigRequired{E: "preset"}
+	err := Load(&result, FlagSet(f), Env(WithPrefix("LOAD_REQUIRED")))
+	require.Error(err)
+	var missingErr *MissingFieldsError
+	require.True(errors.As(err, &missingErr))
+	assert.Equal([]MissingField{
+		{Path: "A", Candidates: []Source{{Kind: SourceEnv, Name: "LOAD_REQUIRED_A"}}},
+		{Path: "B", Candidates: []Source{{Kind: SourceFlag, Name: "b"}}},
+		{Path: "C"},
+	}, missingErr.Fields)
+	assert.Equal("missing required fields: '.A' (env LOAD_REQUIRED_A), '.B' (flag b), '.C'", err.Error())
+}
+
+type testRoute struct {
+	Path string `json:"path"`
+}
+
+func TestLoadNestedLeaves(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	type config struct {
+		R testRoute `env:"R,json" copre:",required"`
+		S testRoute `env:"S,json,required"`
+		T testRoute `copre:",required"`
+	}
+
+	env := map[string]string{"R": `{"path":"/r"}`, "S": `{"path":"/s"}`}
+	result := config{}
+	report, err := LoadWithReport(&result, Env(FromMap(env)))
+	require.Error(err)
+	assert.Equal(testRoute{Path: "/r"}, result.R)
+	assert.Equal(Report{
+		"R":      {Kind: SourceEnv, Name: "R"},
+		"S":      {Kind: SourceEnv, Name: "S"},
+		"T.Path": {Kind: SourcePreset},
+	}, report)
+	var missingErr *MissingFieldsError
+	require.True(errors.As(err, &missingErr))
+	assert.Equal([]MissingField{{Path: "T"}}, missingErr.Fields)
+
+	// Nested structs are required as a whole
+	result = config{T: testRoute{Path: "/t"}}
+	_, err = LoadWithReport(&result, Env(FromMap(map[string]string{"R": `{"path":"/r"}`})))
+	require.True(errors.As(err, &missingErr))
+	assert.Equal([]MissingField{
+		{Path: "S", Candidates: []Source{{Kind: SourceEnv, Name: "S"}}},
+	}, missingErr.Fields)
+}
+
+type TestConfigCollectErrors struct {
+	A int    `env:"A"`
+	B bool   `env:"B"`
+	C string `env:"C"`
+	D string `flag:"d"`
+	E string `copre:",required"`
+}
+
+func TestLoadCollectErrors(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	f := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	f.Int("d", 0, "")
+	require.NoError(f.Parse([]string{"--d=1"}))
+	os.Setenv("LOAD_COLLECT_ERRORS_A", "a")
+	os.Setenv("LOAD_COLLECT_ERRORS_B", "b")
+	os.Setenv("LOAD_COLLECT_ERRORS_C", "c")
+
+	result := TestConfigCollectErrors{}
+	_, err := LoadWithOptions(&result, []Loader{
+		Env(WithPrefix("LOAD_COLLECT_ERRORS")),
+		File("does/not/exist", json.Unmarshal),
+		FlagSet(f),
+	}, CollectErrors())
+	require.Error(err)
+	var multiErr *MultiError
+	require.True(errors.As(err, &multiErr))
+	assert.Len(multiErr.Errors, 5)
+	fieldErrs := multiErr.FieldErrors()
+	require.Len(fieldErrs, 3)
+	assert.Equal("A", fieldErrs[0].Path)
+	assert.Equal(Source{Kind: SourceEnv, Name: "LOAD_COLLECT_ERRORS_A"}, fieldErrs[0].Source)
+	assert.Equal("a", fieldErrs[0].RawValue)
+	assert.Equal("B", fieldErrs[1].Path)
+	assert.Equal("D", fieldErrs[2].Path)
+	assert.Equal(Source{Kind: SourceFlag, Name: "d"}, fieldErrs[2].Source)
+	var missingErr *MissingFieldsError
+	assert.True(errors.As(multiErr.Errors[4], &missingErr))
+	// Fields without errors are still populated
+	assert.Equal("c", result.C)
+
+	// Without the option the first error is returned
+	err = Load(&result, Env(WithPrefix("LOAD_COLLECT_ERRORS")))
+	require.Error(err)
+	var fieldErr *FieldError
+	require.True(errors.As(err, &fieldErr))
+	assert.Equal("A", fieldErr.Path)
+}
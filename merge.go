@@ -0,0 +1,109 @@
+package copre
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// MergeStrategy determines how Load merges a slice or map populated by a
+// loader with the value already present. The strategy can be set for all
+// slices or maps using MergeSlices or MergeMaps, or for individual fields
+// using the "copre"-tag, e.g. `copre:",merge=unique"`.
+type MergeStrategy string
+
+const (
+	// MergeReplace replaces previous values, this is the default.
+	MergeReplace MergeStrategy = "replace"
+	// MergeAppend appends the elements of a slice to previous values.
+	MergeAppend MergeStrategy = "append"
+	// MergeAppendUnique appends the elements of a slice to previous values,
+	// unless they are already present.
+	MergeAppendUnique MergeStrategy = "unique"
+	// MergeDeep merges the keys of a map into previous values. If both values
+	// of the same key are maps themselves, they are merged recursively.
+	MergeDeep MergeStrategy = "deep"
+)
+
+// validate returns an error if the strategy can not be used for values of
+// kind k.
+func (s MergeStrategy) validate(k reflect.Kind) error {
+	switch s {
+	case MergeReplace:
+		return nil
+	case MergeAppend, MergeAppendUnique:
+		if k == reflect.Slice {
+			return nil
+		}
+	case MergeDeep:
+		if k == reflect.Map {
+			return nil
+		}
+	default:
+		return fmt.Errorf("unknown merge strategy '%s'", s)
+	}
+	return fmt.Errorf("merge strategy '%s' not supported for kind '%s'", s, k)
+}
+
+// mergeValue merges src into dst using strategy, which is expected to be
+// valid for the kind of the values.
+func mergeValue(dst, src reflect.Value, strategy MergeStrategy) {
+	switch strategy {
+	case MergeAppend:
+		dst.Set(reflect.AppendSlice(dst, src))
+	case MergeAppendUnique:
+		merged := dst
+		for i := 0; i < src.Len(); i++ {
+			if !containsValue(merged, src.Index(i)) {
+				merged = reflect.Append(merged, src.Index(i))
+			}
+		}
+		dst.Set(merged)
+	case MergeDeep:
+		dst.Set(mergeMaps(dst, src))
+	default:
+		dst.Set(src)
+	}
+}
+
+// containsValue reports whether slice s contains an element deeply equal to v.
+func containsValue(s, v reflect.Value) bool {
+	for i := 0; i < s.Len(); i++ {
+		if reflect.DeepEqual(s.Index(i).Interface(), v.Interface()) {
+			return true
+		}
+	}
+	return false
+}
+
+// mergeMaps returns a new map containing the keys of dst and src. Values of
+// src take precedence, unless both values are maps, which are merged
+// recursively.
+func mergeMaps(dst, src reflect.Value) reflect.Value {
+	if src.IsNil() {
+		return dst
+	}
+	merged := reflect.MakeMapWithSize(src.Type(), dst.Len()+src.Len())
+	iter := dst.MapRange()
+	for iter.Next() {
+		merged.SetMapIndex(iter.Key(), iter.Value())
+	}
+	iter = src.MapRange()
+	for iter.Next() {
+		k, v := iter.Key(), iter.Value()
+		if prev := merged.MapIndex(k); prev.IsValid() {
+			prev, next := unwrapInterface(prev), unwrapInterface(v)
+			if prev.Kind() == reflect.Map && next.Kind() == reflect.Map && prev.Type() == next.Type() {
+				v = mergeMaps(prev, next)
+			}
+		}
+		merged.SetMapIndex(k, v)
+	}
+	return merged
+}
+
+func unwrapInterface(v reflect.Value) reflect.Value {
+	if v.Kind() == reflect.Interface && !v.IsNil() {
+		return v.Elem()
+	}
+	return v
+}
@@ -0,0 +1,61 @@
+package copre
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type TestConfigMerge struct {
+	A []string                          `env:"A"`
+	B []string                          `env:"B" copre:",merge=replace"`
+	C []string                          `env:"C" copre:",merge=unique"`
+	D map[string]string                 `env:"D"`
+	E map[string]map[string]interface{} `copre:",merge=deep"`
+}
+
+func TestMergeStrategies(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	os.Setenv("MERGE_STRATEGIES_A", "b,c")
+	os.Setenv("MERGE_STRATEGIES_B", "b,c")
+	os.Setenv("MERGE_STRATEGIES_C", "b,c")
+	os.Setenv("MERGE_STRATEGIES_D", "b=env,c=env")
+
+	result := TestConfigMerge{
+		A: []string{"a", "b"},
+		B: []string{"a", "b"},
+		C: []string{"a", "b"},
+		D: map[string]string{"a": "preset", "b": "preset"},
+		E: map[string]map[string]interface{}{"a": {"a": 1, "b": 1}},
+	}
+	_, err := LoadWithOptions(&result, []Loader{
+		Env(WithPrefix("MERGE_STRATEGIES")),
+		LoaderFunc(func(dst interface{}) error {
+			dst.(*TestConfigMerge).E = map[string]map[string]interface{}{"a": {"b": 2}, "b": {"a": 2}}
+			return nil
+		}),
+	}, MergeSlices(MergeAppend), MergeMaps(MergeDeep))
+	require.NoError(err)
+	assert.Equal(TestConfigMerge{
+		A: []string{"a", "b", "b", "c"},
+		B: []string{"b", "c"},
+		C: []string{"a", "b", "c"},
+		D: map[string]string{"a": "preset", "b": "env", "c": "env"},
+		E: map[string]map[string]interface{}{"a": {"a": 1, "b": 2}, "b": {"a": 2}},
+	}, result)
+}
+
+func TestMergeStrategyInvalid(t *testing.T) {
+	result := struct {
+		A string `env:"A" copre:",merge=append"`
+	}{}
+	os.Setenv("MERGE_STRATEGY_INVALID_A", "a")
+	err := Load(&result, Env(WithPrefix("MERGE_STRATEGY_INVALID")))
+	assert.Error(t, err)
+	_, err = LoadWithOptions(&result, nil, MergeMaps(MergeAppend))
+	assert.Error(t, err)
+}
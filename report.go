@@ -0,0 +1,187 @@
+package copre
+
+import (
+	"reflect"
+	"strings"
+)
+
+// The kinds of sources a field value can originate from. See Source.
+const (
+	SourcePreset  = "preset"
+	SourceDefault = "default"
+	SourceEnv     = "env"
+	SourceFlag    = "flag"
+	SourceFile    = "file"
+	SourceLoader  = "loader"
+)
+
+// Source describes where the value of a field originates from.
+type Source struct {
+	// Kind is the kind of source, e.g. SourceEnv or SourceFile.
+	Kind string
+	// Name identifies the source within its kind, e.g. the environment
+	// variable, flag name or file path. Empty for preset and default values.
+	Name string
+}
+
+// String returns a human-readable representation of the source, e.g.
+// "env MYAPP_PORT" or "file /etc/myapp/config.yaml".
+func (s Source) String() string {
+	if s.Name == "" {
+		return s.Kind
+	}
+	return s.Kind + " " + s.Name
+}
+
+// Report maps the path of every field, as passed to a FieldMapper by
+// StructWalk and joined with dots, to the Source its final value originates
+// from. Fields that were not set by any Loader keep the value set prior to
+// loading and are therefore reported as SourcePreset.
+//
+// For example:
+//  report["Server.Port"].String() // returns "env MYAPP_SERVER_PORT"
+type Report map[string]Source
+
+// tracker records which fields a Loader populated and from which source.
+// The loaders of this package accept a nil tracker, which is the case if they
+// are used standalone rather than by Load.
+// Additionally loaders can report sources, that could have populated a field,
+// and fields, that are required.
+// If collectErrors is set, loaders should not stop at errors related to
+// individual fields, but return them as *MultiError after processing all
+// fields.
+type tracker struct {
+	sources       map[string]Source
+	candidates    map[string][]Source
+	required      map[string]bool
+	collectErrors bool
+}
+
+func newTracker() *tracker {
+	return &tracker{
+		sources:    map[string]Source{},
+		candidates: map[string][]Source{},
+		required:   map[string]bool{},
+	}
+}
+
+func (t *tracker) track(path []string, src Source) {
+	if t == nil {
+		return
+	}
+	t.sources[strings.Join(path, ".")] = src
+}
+
+func (t *tracker) consider(path []string, src Source) {
+	if t == nil {
+		return
+	}
+	key := strings.Join(path, ".")
+	t.candidates[key] = append(t.candidates[key], src)
+}
+
+func (t *tracker) collecting() bool {
+	return t != nil && t.collectErrors
+}
+
+func (t *tracker) require(path []string) {
+	if t == nil {
+		return
+	}
+	t.required[strings.Join(path, ".")] = true
+}
+
+// trackedLoader is implemented by the loaders of this package to report the
+// fields they populated to Load.
+type trackedLoader interface {
+	Loader
+	processTracked(dst interface{}, t *tracker) error
+}
+
+// trackedLoaderFunc is the counterpart of LoaderFunc implementing
+// trackedLoader.
+type trackedLoaderFunc func(dst interface{}, t *tracker) error
+
+// Process calls the trackedLoaderFunc underneath without a tracker.
+func (fn trackedLoaderFunc) Process(dst interface{}) error {
+	return fn(dst, nil)
+}
+
+func (fn trackedLoaderFunc) processTracked(dst interface{}, t *tracker) error {
+	return fn(dst, t)
+}
+
+// visitLeaves calls fn for every exported field of struct v, that is not a
+// nested struct or pointer to one, mirroring the traversal of StructWalk.
+// Nil pointers to structs are visited as if they pointed to a zero value.
+func visitLeaves(v reflect.Value, fn func(path []string, field reflect.StructField, v reflect.Value)) {
+	visitLeavesPath(v, []string{}, map[reflect.Type]bool{}, false, fn)
+}
+
+// visitFields is similar to visitLeaves, but fn is called for fields of
+// nested structs as well, prior to their fields.
+func visitFields(v reflect.Value, fn func(path []string, field reflect.StructField, v reflect.Value)) {
+	visitLeavesPath(v, []string{}, map[reflect.Type]bool{}, true, fn)
+}
+
+func visitLeavesPath(v reflect.Value, path []string, seen map[reflect.Type]bool, structs bool, fn func([]string, reflect.StructField, reflect.Value)) {
+	t := v.Type()
+	// Recursive types would lead to endless traversal, so let's stop there
+	if seen[t] {
+		return
+	}
+	seen[t] = true
+	defer delete(seen, t)
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.PkgPath != "" { // unexported
+			continue
+		}
+		f := v.Field(i)
+		fieldPath := append(path[:len(path):len(path)], sf.Name)
+		if isNestedStruct(sf.Type) {
+			if structs {
+				fn(fieldPath, sf, f)
+			}
+			if f.Kind() == reflect.Ptr {
+				if f.IsNil() {
+					f = reflect.New(f.Type().Elem())
+				}
+				f = f.Elem()
+			}
+			visitLeavesPath(f, fieldPath, seen, structs, fn)
+			continue
+		}
+		fn(fieldPath, sf, f)
+	}
+}
+
+// sourceOf returns the source of the field at key according to report. As
+// loaders can populate nested structs as a whole, the source of the closest
+// parent is returned, if the field itself is not reported.
+func (r Report) sourceOf(key string) Source {
+	for {
+		if src, ok := r[key]; ok {
+			return src
+		}
+		i := strings.LastIndex(key, ".")
+		if i < 0 {
+			return Source{Kind: SourcePreset}
+		}
+		key = key[:i]
+	}
+}
+
+// populated reports whether the field at key or any of its nested fields was
+// populated by a loader.
+func (r Report) populated(key string) bool {
+	if r.sourceOf(key).Kind != SourcePreset {
+		return true
+	}
+	for k, src := range r {
+		if strings.HasPrefix(k, key+".") && src.Kind != SourcePreset {
+			return true
+		}
+	}
+	return false
+}
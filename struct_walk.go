@@ -1,31 +1,68 @@
 package copre
 
 import (
+	"encoding"
+	"encoding/json"
+	"flag"
 	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
 	"reflect"
+	"regexp"
 	"strings"
+	"time"
 
 	"github.com/mitchellh/reflectwalk"
 )
 
 // FieldMapper is a function that takes the path of a field in a nested structure
 // and field itself, to return a value or an error.
+// For fields of pointer type, either a value of the pointer or element type can
+// be returned. Pointers are only allocated if a non-nil value is returned.
 type FieldMapper func(path []string, field reflect.StructField) (interface{}, error)
 
 // StructWalk walks/visits every field of a struct (including nested) and calls
 // fieldMapper for every field. If an internal error is encountered or an error
 // is returned by fieldMapper it is immediately returned and traversal stopped.
 func StructWalk(dst interface{}, fieldMapper FieldMapper) error {
+	return structWalk(dst, fieldMapper, walkOptions{})
+}
+
+// walkOptions configure the behavior of structWalk beyond StructWalk.
+type walkOptions struct {
+	// If collectErrors is set, traversal is not stopped by errors related to
+	// individual fields. Instead those errors are collected and returned as
+	// *MultiError after all fields were visited.
+	collectErrors bool
+	// isLeaf optionally marks fields of nested structs as leaves, so they are
+	// passed to the FieldMapper rather than walked into.
+	isLeaf func(field reflect.StructField) bool
+}
+
+// structWalk implements StructWalk with additional options.
+func structWalk(dst interface{}, fieldMapper FieldMapper, o walkOptions) error {
 	w := &structWalker{
-		Path:        []string{},
-		FieldMapper: fieldMapper,
+		Path:          []string{},
+		FieldMapper:   fieldMapper,
+		CollectErrors: o.collectErrors,
+		IsLeaf:        o.isLeaf,
 	}
-	return reflectwalk.Walk(dst, w)
+	if err := reflectwalk.Walk(dst, w); err != nil {
+		return err
+	}
+	if len(w.Errors) > 0 {
+		return &MultiError{Errors: w.Errors}
+	}
+	return nil
 }
 
 type structWalker struct {
-	Path        []string
-	FieldMapper FieldMapper
+	Path          []string
+	FieldMapper   FieldMapper
+	CollectErrors bool
+	IsLeaf        func(reflect.StructField) bool
+	Errors        []error
 }
 
 func (w *structWalker) Enter(l reflectwalk.Location) error {
@@ -44,27 +81,38 @@ func (w *structWalker) Struct(v reflect.Value) error {
 }
 
 func (w *structWalker) StructField(sf reflect.StructField, v reflect.Value) (err error) {
-	if sf.Type.Kind() == reflect.Ptr {
-		// For pointers that are nil we try to set the default value
-		if v.IsNil() {
+	// Unexported fields can not be set, so let's skip them
+	if sf.PkgPath != "" {
+		return reflectwalk.SkipEntry
+	}
+
+	// If type is a nested struct or pointer to one, append path
+	if isNestedStruct(sf.Type) && (w.IsLeaf == nil || !w.IsLeaf(sf)) {
+		// For pointers that are nil we try to set the default value,
+		// so the nested struct can be walked
+		if sf.Type.Kind() == reflect.Ptr && v.IsNil() {
 			if !v.CanSet() {
 				return
 			}
-			ptr := reflect.New(sf.Type.Elem())
-			v.Set(ptr)
+			v.Set(reflect.New(sf.Type.Elem()))
 		}
-		// We do not mutate pointers, so let's sets retrieve element
-		v = v.Elem()
-	}
-
-	// If type is struct or pointer to struct, append path
-	if sf.Type.Kind() == reflect.Struct || (sf.Type.Kind() == reflect.Ptr && sf.Type.Elem().Kind() == reflect.Struct) {
 		w.Path = append(w.Path, sf.Name)
 		return
 	}
 
 	var result interface{}
 	path := append(w.Path, sf.Name)
+	// Errors related to this field are either collected or returned.
+	// Either way the value itself is not walked, as it is handled as a whole.
+	defer func() {
+		if err != nil && w.CollectErrors {
+			w.Errors = append(w.Errors, errorList(err)...)
+			err = nil
+		}
+		if err == nil {
+			err = reflectwalk.SkipEntry
+		}
+	}()
 	result, err = w.FieldMapper(path, sf)
 	if err != nil {
 		return
@@ -76,11 +124,73 @@ func (w *structWalker) StructField(sf reflect.StructField, v reflect.Value) (err
 		return nil
 	}
 
+	mismatchErr := &FieldError{
+		Path:       strings.Join(path, "."),
+		TargetType: sf.Type,
+		Err:        fmt.Errorf("%w: expected type '%s', got '%T'", ErrTypeMismatch, sf.Type.String(), result),
+	}
 	defer func() {
 		if recover() != nil {
-			err = fmt.Errorf("failed to set value at path '.%s': expected type '%s', got '%T'.", strings.Join(path, "."), v.Type().String(), result)
+			err = mismatchErr
 		}
 	}()
-	v.Set(reflect.ValueOf(result))
-	return
+	rv := reflect.ValueOf(result)
+	if rv.Type().AssignableTo(sf.Type) {
+		v.Set(rv)
+		return
+	}
+	// Pointers are only allocated if a value for the element is returned,
+	// so pointers remain nil, if the FieldMapper did not return a value.
+	if sf.Type.Kind() == reflect.Ptr && rv.Type().AssignableTo(sf.Type.Elem()) {
+		ptr := reflect.New(sf.Type.Elem())
+		ptr.Elem().Set(rv)
+		v.Set(ptr)
+		return
+	}
+	return mismatchErr
+}
+
+var (
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+	jsonUnmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+	flagValueType       = reflect.TypeOf((*flag.Value)(nil)).Elem()
+)
+
+// leafStructTypes are structs, that are handled as a whole by loaders rather
+// than by their fields.
+var leafStructTypes = map[reflect.Type]bool{
+	reflect.TypeOf(net.IPNet{}):     true,
+	reflect.TypeOf(netip.Addr{}):    true,
+	reflect.TypeOf(netip.Prefix{}):  true,
+	reflect.TypeOf(time.Time{}):     true,
+	reflect.TypeOf(time.Location{}): true,
+	reflect.TypeOf(url.URL{}):       true,
+	reflect.TypeOf(regexp.Regexp{}): true,
+}
+
+// isNestedStruct reports whether t is a struct or pointer to struct, whose
+// fields should be visited individually. This is not the case for structs
+// that know how to unmarshal themselves from text, i.e. by implementing
+// encoding.TextUnmarshaler or flag.Value, or are otherwise supported as a
+// whole. Structs implementing only json.Unmarshaler are still visited, as
+// this is common for configuration sections.
+func isNestedStruct(t reflect.Type) bool {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.Kind() == reflect.Struct && !leafStructTypes[t] && !isTextUnmarshaler(t)
+}
+
+// isTextUnmarshaler reports whether a pointer to t implements
+// encoding.TextUnmarshaler or flag.Value.
+func isTextUnmarshaler(t reflect.Type) bool {
+	p := reflect.PtrTo(t)
+	return p.Implements(textUnmarshalerType) || p.Implements(flagValueType)
+}
+
+// isUnmarshaler reports whether a pointer to t implements one of the supported
+// interfaces to unmarshal a value from a string.
+func isUnmarshaler(t reflect.Type) bool {
+	p := reflect.PtrTo(t)
+	return p.Implements(textUnmarshalerType) || p.Implements(jsonUnmarshalerType) || p.Implements(flagValueType)
 }
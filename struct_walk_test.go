@@ -1,6 +1,7 @@
 package copre
 
 import (
+	"errors"
 	"reflect"
 	"testing"
 
@@ -37,3 +38,45 @@ func TestStructWalkerIncompatibleTypes(t *testing.T) {
 	})
 	assert.Error(err)
 }
+
+func TestStructWalkerCollectErrors(t *testing.T) {
+	assert := assert.New(t)
+	dst := struct {
+		A string
+		B string
+		C string
+	}{}
+	err := structWalk(&dst, func(path []string, field reflect.StructField) (interface{}, error) {
+		if path[0] == "B" {
+			return "value", nil
+		}
+		return 1, nil
+	}, walkOptions{collectErrors: true})
+	var multiErr *MultiError
+	assert.True(errors.As(err, &multiErr))
+	assert.Len(multiErr.FieldErrors(), 2)
+	assert.Equal("value", dst.B)
+}
+
+func TestStructWalkerPointers(t *testing.T) {
+	assert := assert.New(t)
+	value := "value"
+	dst := struct {
+		A *string
+		B *string
+		C *string
+	}{}
+	err := StructWalk(&dst, func(path []string, field reflect.StructField) (interface{}, error) {
+		switch path[0] {
+		case "A":
+			return "value", nil
+		case "B":
+			return &value, nil
+		}
+		return nil, nil
+	})
+	assert.NoError(err)
+	assert.Equal("value", *dst.A)
+	assert.Equal(&value, dst.B)
+	assert.Nil(dst.C)
+}